@@ -0,0 +1,102 @@
+package sockjs
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	errSessionLimit   = errors.New("sockjs: session not admitted")
+	sessionLimitFrame = closeFrame(1013, "Session limit reached")
+)
+
+// Admission describes the handler load at the time a new session is about to be created.
+// It is passed to Options.AdmitSession.
+type Admission struct {
+	// RemoteAddr is the IP address of the client, resolved according to Options.TrustedProxies.
+	RemoteAddr string
+	// Sessions is the number of sessions currently open in the handler.
+	Sessions int
+	// SessionsFromIP is the number of sessions currently open from the same client address.
+	SessionsFromIP int
+	// LimitExceeded is true if admitting the session would exceed Options.MaxSessions or Options.MaxSessionsPerIP.
+	LimitExceeded bool
+}
+
+// admit decides whether a new session can be created for the request. When admitted, the returned
+// release function must be called once the session is closed. Sessions are counted without locking,
+// only per address counts (needed for MaxSessionsPerIP and AdmitSession) are kept under admissionMux.
+func (h *Handler) admit(req *http.Request) (release func(), err error) {
+	if h.shuttingDown.Load() {
+		return nil, errSessionLimit
+	}
+	ip := h.trustedProxies.clientIP(req)
+	countIP := h.options.MaxSessionsPerIP > 0 || h.options.AdmitSession != nil
+	if h.options.AdmitSession != nil {
+		a := Admission{
+			RemoteAddr:     ip,
+			Sessions:       int(h.openSessions.Load()),
+			SessionsFromIP: h.sessionsFromIP(ip),
+		}
+		a.LimitExceeded = (h.options.MaxSessions > 0 && a.Sessions >= h.options.MaxSessions) ||
+			(h.options.MaxSessionsPerIP > 0 && a.SessionsFromIP >= h.options.MaxSessionsPerIP)
+		if !h.options.AdmitSession(req, a) {
+			return nil, errSessionLimit
+		}
+		h.openSessions.Add(1)
+		h.addSessionFromIP(ip, 0)
+	} else {
+		if n := h.openSessions.Add(1); h.options.MaxSessions > 0 && n > int64(h.options.MaxSessions) {
+			h.openSessions.Add(-1)
+			return nil, errSessionLimit
+		}
+		if countIP && !h.addSessionFromIP(ip, h.options.MaxSessionsPerIP) {
+			h.openSessions.Add(-1)
+			return nil, errSessionLimit
+		}
+	}
+	return func() {
+		h.openSessions.Add(-1)
+		if countIP {
+			h.removeSessionFromIP(ip)
+		}
+	}, nil
+}
+
+func (h *Handler) sessionsFromIP(ip string) int {
+	h.admissionMux.Lock()
+	defer h.admissionMux.Unlock()
+	return h.openSessionsByIP[ip]
+}
+
+// addSessionFromIP counts a session from ip, unless there are limit sessions from ip already (zero means no limit)
+func (h *Handler) addSessionFromIP(ip string, limit int) bool {
+	h.admissionMux.Lock()
+	defer h.admissionMux.Unlock()
+	if limit > 0 && h.openSessionsByIP[ip] >= limit {
+		return false
+	}
+	if h.openSessionsByIP == nil {
+		h.openSessionsByIP = make(map[string]int)
+	}
+	h.openSessionsByIP[ip]++
+	return true
+}
+
+func (h *Handler) removeSessionFromIP(ip string) {
+	h.admissionMux.Lock()
+	defer h.admissionMux.Unlock()
+	if h.openSessionsByIP[ip]--; h.openSessionsByIP[ip] <= 0 {
+		delete(h.openSessionsByIP, ip)
+	}
+}
+
+// sessionError reports an error returned by sessionByRequest to the client. Refused sessions are closed
+// with a close frame written by transport's frame writer, as the response status might have been sent already.
+func sessionError(rw http.ResponseWriter, fw frameWriter, err error) {
+	if err == errSessionLimit {
+		_, _ = fw.write(rw, []byte(sessionLimitFrame))
+		return
+	}
+	http.Error(rw, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,129 @@
+package sockjs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandler_MaxSessions(t *testing.T) {
+	h := newTestHandler()
+	h.options.MaxSessions = 1
+	h.options.DisconnectDelay = 10 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/session1/xhr", nil)
+	withSessionID(h.xhrPoll)(rec, req)
+	if rec.Body.String() != "o\n" {
+		t.Errorf("First session should be admitted, got '%s'", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/server/session2/xhr", nil)
+	withSessionID(h.xhrPoll)(rec, req)
+	if rec.Body.String() != sessionLimitFrame+"\n" {
+		t.Errorf("Second session should be refused, got '%s'", rec.Body.String())
+	}
+
+	// limit is released once the first session times out
+	for deadline := time.Now().Add(time.Second); h.Stats().OpenSessions != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("First session should time out, %d sessions open", h.Stats().OpenSessions)
+		}
+	}
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/server/session3/xhr", nil)
+	withSessionID(h.xhrPoll)(rec, req)
+	if rec.Body.String() != "o\n" {
+		t.Errorf("Session should be admitted after the previous one closed, got '%s'", rec.Body.String())
+	}
+}
+
+func TestHandler_MaxSessionsPerIP(t *testing.T) {
+	h := newTestHandler()
+	h.options.MaxSessionsPerIP = 1
+
+	for _, c := range []struct {
+		session, remoteAddr, expected string
+	}{
+		{"session1", "10.0.0.1:1000", "o\n"},
+		{"session2", "10.0.0.1:1001", sessionLimitFrame + "\n"},
+		{"session3", "10.0.0.2:1000", "o\n"},
+	} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/"+c.session+"/xhr", nil)
+		req.RemoteAddr = c.remoteAddr
+		withSessionID(h.xhrPoll)(rec, req)
+		if rec.Body.String() != c.expected {
+			t.Errorf("Unexpected response for '%s' from '%s', got '%s' expected '%s'", c.session, c.remoteAddr, rec.Body.String(), c.expected)
+		}
+	}
+}
+
+func TestHandler_MaxSessionsPerIPBehindProxy(t *testing.T) {
+	h := newTestHandler()
+	h.options.MaxSessionsPerIP = 1
+	h.trustedProxies, _ = parseTrustedProxies([]string{"10.0.0.1"})
+
+	for _, c := range []struct {
+		session, forwardedFor, expected string
+	}{
+		{"session1", "1.1.1.1", "o\n"},
+		{"session2", "2.2.2.2", "o\n"},
+		{"session3", "1.1.1.1", sessionLimitFrame + "\n"},
+	} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/"+c.session+"/xhr", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("X-Forwarded-For", c.forwardedFor)
+		withSessionID(h.xhrPoll)(rec, req)
+		if rec.Body.String() != c.expected {
+			t.Errorf("Unexpected response for '%s' from '%s', got '%s' expected '%s'", c.session, c.forwardedFor, rec.Body.String(), c.expected)
+		}
+	}
+	if sess, _ := h.sessions.get("session2"); sess.RemoteAddr() != "2.2.2.2" {
+		t.Errorf("Session should expose resolved address, got '%s'", sess.RemoteAddr())
+	}
+}
+
+func TestHandler_AdmitSession(t *testing.T) {
+	h := newTestHandler()
+	h.options.MaxSessions = 1
+	var admissions []Admission
+	h.options.AdmitSession = func(req *http.Request, a Admission) bool {
+		admissions = append(admissions, a)
+		return req.Header.Get("X-Priority") != "" || !a.LimitExceeded
+	}
+	for i, priority := range []string{"", "", "high"} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/session"+strconv.Itoa(i)+"/xhr", nil)
+		req.Header.Set("X-Priority", priority)
+		withSessionID(h.xhrPoll)(rec, req)
+		admitted := strings.HasPrefix(rec.Body.String(), "o")
+		if expected := i != 1; admitted != expected {
+			t.Errorf("Unexpected admission of session %d, got '%v' expected '%v'", i, admitted, expected)
+		}
+	}
+	if len(admissions) != 3 || admissions[2].Sessions != 1 || !admissions[2].LimitExceeded {
+		t.Errorf("Unexpected admission requests '%+v'", admissions)
+	}
+}
+
+func TestHandler_RawWebSocketSessionRefused(t *testing.T) {
+	h := newTestHandler()
+	h.options.AdmitSession = func(*http.Request, Admission) bool { return false }
+	server := httptest.NewServer(http.HandlerFunc(h.rawWebsocket))
+	defer server.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err == nil {
+		t.Fatalf("Websocket handshake should fail")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response code, got '%d', expected '%d'", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
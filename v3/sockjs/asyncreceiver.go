@@ -0,0 +1,250 @@
+package sockjs
+
+import "sync"
+
+// asyncWrite is a single queued operation of asyncReceiver
+type asyncWrite struct {
+	messages []string      // data messages to be sent in one frame
+	meta     []messageMeta // send options of messages, returned with them if they are not written
+	seq      uint64        // delivery sequence of the session settled once messages are written
+	frame    string        // raw frame, used if messages is nil
+	close    bool          // close the underlying receiver
+}
+
+// messageBatch holds data messages with their send options, meta is nil unless some message has options
+type messageBatch struct {
+	messages []string
+	meta     []messageMeta
+}
+
+func (b *messageBatch) append(messages []string, meta []messageMeta) {
+	if meta != nil && b.meta == nil {
+		b.meta = make([]messageMeta, len(b.messages), len(b.messages)+len(messages))
+	}
+	if b.meta != nil {
+		if meta == nil {
+			meta = make([]messageMeta, len(messages))
+		}
+		b.meta = append(b.meta, meta...)
+	}
+	b.messages = append(b.messages, messages...)
+}
+
+// asyncReceiver decouples writes from the caller. Frames are queued and written to the wrapped
+// receiver by a dedicated goroutine, so the session lock is never held while waiting for network I/O.
+type asyncReceiver struct {
+	receiver
+
+	mux     sync.Mutex
+	queue   []asyncWrite
+	closed  bool  // close was requested, no more writes accepted
+	err     error // first write error, receiver is unusable afterwards
+	signal  chan struct{}
+	onError func(error)
+	// onSettled reports the number of data messages written to the wrapped receiver with the delivery
+	// sequence they settle, and messages returned unwritten once the wrapped receiver ended or failed
+	onSettled func(written int, seq uint64, unsent messageBatch)
+}
+
+func newAsyncReceiver(recv receiver, onError func(error), onSettled func(written int, seq uint64, unsent messageBatch)) *asyncReceiver {
+	a := &asyncReceiver{
+		receiver:  recv,
+		signal:    make(chan struct{}, 1),
+		onError:   onError,
+		onSettled: onSettled,
+	}
+	go a.loop()
+	return a
+}
+
+// sendBulk queues messages to be sent. The messages slice must not be modified by the caller afterwards.
+func (a *asyncReceiver) sendBulk(messages ...string) error {
+	return a.sendBulkSeq(0, messages, nil)
+}
+
+// sendBulkSeq queues messages with their send options (nil if none) to be sent, seq is reported by onSettled
+// once they are written (even if there are no messages, i.e. all expired)
+func (a *asyncReceiver) sendBulkSeq(seq uint64, messages []string, meta []messageMeta) error {
+	if len(messages) == 0 && seq == 0 {
+		return nil
+	}
+	if messages == nil {
+		messages = []string{}
+	}
+	return a.enqueue(asyncWrite{messages: messages, meta: meta, seq: seq})
+}
+
+func (a *asyncReceiver) sendFrame(frame string) error {
+	return a.enqueue(asyncWrite{frame: frame})
+}
+
+func (a *asyncReceiver) enqueue(w asyncWrite) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	if a.closed {
+		return nil
+	}
+	a.queue = append(a.queue, w)
+	a.notify()
+	return nil
+}
+
+func (a *asyncReceiver) notify() {
+	select {
+	case a.signal <- struct{}{}:
+	default: // writer already notified
+	}
+}
+
+// close closes the wrapped receiver once all previously queued writes are done (idempotent)
+func (a *asyncReceiver) close() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if !a.closed {
+		a.closed = true
+		a.queue = append(a.queue, asyncWrite{close: true})
+		a.notify()
+	}
+}
+
+// takeover closes the receiver once it writes queued frames and the close frame, and returns data messages
+// queued but not yet written with the delivery sequence settled by dropping them (see unwrittenMessages)
+func (a *asyncReceiver) takeover(closeFrame string) (messageBatch, uint64) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	unsent, seq := unwrittenMessages(a.queue)
+	kept := a.queue[:0]
+	for _, w := range a.queue {
+		if w.messages == nil {
+			kept = append(kept, w)
+		}
+	}
+	a.queue = kept
+	if !a.closed {
+		a.closed = true
+		a.queue = append(a.queue, asyncWrite{frame: closeFrame}, asyncWrite{close: true})
+		a.notify()
+	}
+	return unsent, seq
+}
+
+func (a *asyncReceiver) canSend() bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return !a.closed && a.err == nil && a.receiver.canSend()
+}
+
+func (a *asyncReceiver) loop() {
+	for {
+		select {
+		case <-a.signal:
+		case <-a.receiver.doneNotify():
+			a.fail(errReceiverClosed, nil)
+			return
+		case <-a.receiver.interruptedNotify():
+			a.fail(errReceiverClosed, nil)
+			return
+		}
+		for {
+			a.mux.Lock()
+			queue := a.queue
+			a.queue = nil
+			a.mux.Unlock()
+			if len(queue) == 0 {
+				break
+			}
+			if unwritten, err := a.write(queue); err != nil {
+				a.fail(err, unwritten)
+				if err != errReceiverClosed { // a receiver that ended (i.e. xhr poll got its response) did not fail
+					a.receiver.close()
+					a.onError(err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// fail makes the receiver unusable and returns queued data messages (preceded by unwritten ones
+// already taken from the queue) to the session
+func (a *asyncReceiver) fail(err error, unwritten []asyncWrite) {
+	a.mux.Lock()
+	if a.err == nil {
+		a.err = err
+	}
+	unsent, seq := unwrittenMessages(unwritten, a.queue)
+	a.queue = nil
+	a.mux.Unlock()
+	if len(unsent.messages) > 0 || seq > 0 {
+		a.onSettled(0, seq, unsent)
+	}
+}
+
+// write performs queued operations in order, adjacent data messages are merged into one frame.
+// On error it returns queued operations that were not performed, errReceiverClosed if the wrapped
+// receiver ended meanwhile (i.e. a polling request got its response with the open frame).
+func (a *asyncReceiver) write(queue []asyncWrite) ([]asyncWrite, error) {
+	var pending messageBatch
+	var seq uint64
+	start := 0 // first operation of pending messages
+	for i, w := range queue {
+		if w.messages != nil {
+			pending.append(w.messages, w.meta)
+			seq = w.seq
+			continue
+		}
+		if err := a.sendPending(pending.messages, seq); err != nil {
+			return queue[start:], err
+		}
+		pending, seq, start = messageBatch{}, 0, i+1
+		if w.close {
+			a.receiver.close()
+			return nil, nil
+		}
+		if !a.receiver.canSend() {
+			return queue[i+1:], errReceiverClosed
+		}
+		if err := a.receiver.sendFrame(w.frame); err != nil {
+			return queue[i+1:], err
+		}
+	}
+	if err := a.sendPending(pending.messages, seq); err != nil {
+		return queue[start:], err
+	}
+	return nil, nil
+}
+
+func (a *asyncReceiver) sendPending(pending []string, seq uint64) error {
+	if len(pending) > 0 {
+		if !a.receiver.canSend() {
+			return errReceiverClosed
+		}
+		if err := a.receiver.sendBulk(pending...); err != nil {
+			return err
+		}
+	} else if seq == 0 {
+		return nil
+	}
+	a.onSettled(len(pending), seq, messageBatch{})
+	return nil
+}
+
+// unwrittenMessages collects data messages of queued operations. It also returns the highest delivery sequence
+// of operations without messages (all expired) queued before the first message, dropping them settles it.
+func unwrittenMessages(queues ...[]asyncWrite) (unsent messageBatch, seq uint64) {
+	for _, queue := range queues {
+		for _, w := range queue {
+			if w.messages == nil {
+				continue
+			}
+			if len(w.messages) == 0 && len(unsent.messages) == 0 {
+				seq = w.seq
+			}
+			unsent.append(w.messages, w.meta)
+		}
+	}
+	return unsent, seq
+}
@@ -0,0 +1,161 @@
+package sockjs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingReceiver blocks every write until release channel is closed, optionally failing it.
+// Writing channel is signalled when a write starts blocking.
+type blockingReceiver struct {
+	*testReceiver
+	release chan struct{}
+	writing chan struct{}
+	err     error
+}
+
+func newBlockingReceiver(err error) *blockingReceiver {
+	return &blockingReceiver{testReceiver: newTestReceiver(), release: make(chan struct{}), writing: make(chan struct{}, 1), err: err}
+}
+
+func (b *blockingReceiver) block() {
+	select {
+	case b.writing <- struct{}{}:
+	default:
+	}
+	<-b.release
+}
+
+func (b *blockingReceiver) sendBulk(messages ...string) error {
+	b.block()
+	if b.err != nil {
+		return b.err
+	}
+	return b.testReceiver.sendBulk(messages...)
+}
+
+func (b *blockingReceiver) sendFrame(frame string) error {
+	b.block()
+	if b.err != nil {
+		return b.err
+	}
+	return b.testReceiver.sendFrame(frame)
+}
+
+func (b *blockingReceiver) close() {
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.doneCh:
+	default:
+		close(b.doneCh)
+	}
+}
+
+func TestAsyncReceiver_WritesInOrder(t *testing.T) {
+	inner := newBlockingReceiver(nil)
+	recv := newAsyncReceiver(inner, func(err error) { t.Errorf("Unexpected write error '%v'", err) }, func(int, uint64, messageBatch) {})
+	noError(t, recv.sendFrame("o"))
+	noError(t, recv.sendBulk("message 1"))
+	noError(t, recv.sendBulk("message 2"))
+	noError(t, recv.sendFrame("h"))
+	recv.close()
+	if recv.canSend() {
+		t.Errorf("Receiver should not accept writes after close")
+	}
+	close(inner.release)
+	select {
+	case <-recv.doneNotify():
+	case <-time.After(time.Second):
+		t.Fatalf("Wrapped receiver should be closed after queued writes")
+	}
+	inner.Lock()
+	defer inner.Unlock()
+	expected := []string{"o", "message 1", "message 2", "h"}
+	if len(inner.frames) != len(expected) {
+		t.Fatalf("Unexpected frames written, got '%v' expected '%v'", inner.frames, expected)
+	}
+	for i, frame := range expected {
+		if inner.frames[i] != frame {
+			t.Errorf("Unexpected frame, got '%s' expected '%s'", inner.frames[i], frame)
+		}
+	}
+}
+
+func TestAsyncReceiver_SendDoesNotBlock(t *testing.T) {
+	sess := newTestSession()
+	sess.asyncWriter = true
+	inner := newBlockingReceiver(nil)
+	defer close(inner.release)
+	noError(t, sess.attachReceiver(inner))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			noError(t, sess.Send("message"))
+		}
+		_ = sess.GetSessionState()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Send should not block on slow receiver")
+	}
+}
+
+func TestAsyncReceiver_ErrorClosesSession(t *testing.T) {
+	sess := newTestSession()
+	sess.asyncWriter = true
+	writeErr := errors.New("broken pipe")
+	inner := newBlockingReceiver(writeErr)
+	noError(t, sess.attachReceiver(inner))
+	close(inner.release)
+
+	select {
+	case <-sess.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Session context should be done after write error")
+	}
+	if cause := context.Cause(sess.Context()); !errors.Is(cause, writeErr) {
+		t.Errorf("Unexpected context cause, got '%v' expected '%v'", cause, writeErr)
+	}
+	if sess.GetSessionState() != SessionClosed {
+		t.Errorf("Session should be closed after write error, got '%v'", sess.GetSessionState())
+	}
+}
+
+func TestAsyncReceiver_ConcurrentUse(t *testing.T) {
+	sess := newSession(nil, "id", time.Second, time.Millisecond)
+	sess.asyncWriter = true
+	inner := newBlockingReceiver(nil)
+	close(inner.release)
+	noError(t, sess.attachReceiver(inner))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = sess.Send("message")
+				_ = sess.GetSessionState()
+			}
+		}()
+	}
+	wg.Wait()
+	noError(t, sess.Close(1, "done"))
+	select {
+	case <-inner.doneNotify():
+	case <-time.After(time.Second):
+		t.Fatalf("Receiver should be closed after session close")
+	}
+	inner.Lock()
+	defer inner.Unlock()
+	if last := inner.frames[len(inner.frames)-1]; last != `c[1,"done"]` {
+		t.Errorf("Close frame should be written last, got '%s'", last)
+	}
+}
@@ -2,14 +2,19 @@ package sockjs
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -150,13 +155,310 @@ func BenchmarkMessageWebsocket(b *testing.B) {
 	}
 }
 
-func BenchmarkHandler_ParseSessionID(b *testing.B) {
-	h := Handler{prefix: "/prefix"}
-	url, _ := url.Parse("http://server:80/prefix/server/session/whatever")
+func BenchmarkHttpReceiver_SendBulk(b *testing.B) {
+	messages := []string{"some message", "another <message>", "third message"}
+	req, _ := http.NewRequest("POST", "/server/session/xhr_streaming", nil)
+	recv := newHTTPReceiver(httptest.NewRecorder(), req, math.MaxUint32, new(xhrFrameWriter), ReceiverTypeXHRStreaming)
+	defer recv.close()
 
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = h.parseSessionID(url)
+		recv.rw.(*httptest.ResponseRecorder).Body.Reset()
+		recv.currentResponseSize = 0
+		_ = recv.sendBulk(messages...)
+	}
+}
+
+func BenchmarkBulkFrame(b *testing.B) {
+	messages := []string{"some message", "another <message>", "third message"}
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			quoted := make([]string, len(messages))
+			for i, msg := range messages {
+				bytes, _ := json.Marshal(msg)
+				quoted[i] = string(bytes)
+			}
+			_ = []byte(fmt.Sprintf("a[%s]", strings.Join(quoted, ",")))
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fb := getFrameBuffer()
+			fb.b = appendBulkFrame(fb.b, messages, true)
+			putFrameBuffer(fb)
+		}
+	})
+}
+
+func BenchmarkSession_Send(b *testing.B) {
+	for _, window := range []time.Duration{0, time.Millisecond} {
+		b.Run(fmt.Sprintf("coalesce=%v", window), func(b *testing.B) {
+			server := httptest.NewServer(NewHandler("/echo", Options{
+				Websocket:          true,
+				HeartbeatDelay:     time.Hour,
+				DisconnectDelay:    time.Hour,
+				SendCoalesceWindow: window,
+			}, func(session Session) {
+				for i := 0; i < b.N; i++ {
+					_ = session.Send("some message")
+				}
+				_ = session.Close(1024, "Close")
+			}))
+			defer server.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			client, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/echo/server/0/websocket", nil)
+			if err != nil {
+				b.Fatalf("Dial()=%s", err)
+			}
+			defer client.Close()
+			for {
+				_, p, err := client.ReadMessage()
+				if err != nil || strings.HasPrefix(string(p), "c[1024") {
+					break
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRouter_Match compares path dispatch of the router with matching the former session regexp
+// (which additionally had to be tried for every mapping).
+func BenchmarkRouter_Match(b *testing.B) {
+	h := NewHandler("/prefix", DefaultOptions, nil)
+	paths := []string{"/info", "/server/session/xhr_send", "/server/session/websocket", "/iframe-1.0.html", "/not/found"}
+	b.Run("router", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = h.router.match(paths[i%len(paths)])
+		}
+	})
+	b.Run("regexp", func(b *testing.B) {
+		sessionRegExp := regexp.MustCompile("^/([^/.]+)/([^/.]+)/xhr_send$")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = sessionRegExp.FindStringSubmatch(paths[i%len(paths)])
+		}
+	})
+}
+
+// BenchmarkHandler_ServeHTTP measures routing overhead of requests that are answered by the router or by
+// a short handler chain.
+func BenchmarkHandler_ServeHTTP(b *testing.B) {
+	h := NewHandler("/prefix", DefaultOptions, nil)
+	for _, c := range []struct{ name, method, path string }{
+		{"not found", "GET", "/prefix/server/session/unknown"},
+		{"method not allowed", "GET", "/prefix/server/session/xhr_send"},
+		{"options", "OPTIONS", "/prefix/server/session/xhr"},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			req := httptest.NewRequest(c.method, c.path, nil)
+			rw := &discardResponseWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for k := range rw.header {
+					delete(rw.header, k)
+				}
+				h.ServeHTTP(rw, req)
+			}
+		})
+	}
+}
+
+type discardResponseWriter struct{ header http.Header }
+
+func (rw *discardResponseWriter) Header() http.Header         { return rw.header }
+func (rw *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (rw *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkTimers measures rescheduling of a session timer (as done on every attach, detach and heartbeat)
+// while 100k other session timers are pending.
+func BenchmarkTimers(b *testing.B) {
+	const sessions = 100000
+	schedulers := []struct {
+		name string
+		sc   scheduler
+	}{
+		{"runtime", stdScheduler{}},
+		{"wheel", newTimerWheel(100*time.Millisecond, defaultWheelSlots)},
+		{"sharded wheels", newTimerWheels(100*time.Millisecond, defaultWheelSlots)},
+	}
+	for _, s := range schedulers {
+		b.Run(s.name, func(b *testing.B) {
+			timers := make([]sessionTimer, sessions)
+			for i := range timers {
+				timers[i] = s.sc.AfterFunc(time.Hour, func() {})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s.sc.AfterFunc(25*time.Second, func() {}).Stop()
+				}
+			})
+			b.StopTimer()
+			for _, t := range timers {
+				t.Stop()
+			}
+		})
+	}
+}
+
+// BenchmarkSession_Memory reports memory (heap and stacks) and goroutines retained by an open session with an attached
+// xhr_streaming receiver, served by a running handler function or by an EventHandler. The handler function
+// goroutine is the only one expected per session, sessions of an EventHandler need none.
+func BenchmarkSession_Memory(b *testing.B) {
+	b.Run("handler function", func(b *testing.B) {
+		h := newTestHandler()
+		done := make(chan struct{})
+		h.handlerFunc = func(s Session) { <-done }
+		benchmarkSessionMemory(b, h)
+		close(done)
+	})
+	b.Run("event handler", func(b *testing.B) {
+		benchmarkSessionMemory(b, newTestEventHandler(new(nopEvents)))
+	})
+}
+
+func benchmarkSessionMemory(b *testing.B, h *Handler) {
+	sessions := make([]*session, 0, b.N)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/server/%d/xhr_streaming", i), nil)
+		sess, err := h.sessionByRequest(req, strconv.Itoa(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		recv := newHTTPReceiver(httptest.NewRecorder(), req, h.options.ResponseLimit, new(xhrFrameWriter), ReceiverTypeXHRStreaming)
+		if err := sess.attachReceiver(recv); err != nil {
+			b.Fatal(err)
+		}
+		h.startHandler(sess)
+		sessions = append(sessions, sess)
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	inuse := func(m runtime.MemStats) int64 { return int64(m.HeapInuse + m.StackInuse) }
+	b.ReportMetric(float64(inuse(after)-inuse(before))/float64(b.N), "B/session")
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/session")
+
+	for _, sess := range sessions {
+		sess.close()
+	}
+}
+
+type nopEvents struct{}
+
+func (nopEvents) OnOpen(Session)            {}
+func (nopEvents) OnMessage(Session, string) {}
+func (nopEvents) OnClose(Session, error)    {}
+
+// mutexSessionTable is the former session map guarded by a single handler-wide mutex, kept as a baseline
+type mutexSessionTable struct {
+	mux      sync.Mutex
+	sessions map[string]*session
+}
+
+func (t *mutexSessionTable) get(id string) (*session, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	sess, ok := t.sessions[id]
+	return sess, ok
+}
+
+func (t *mutexSessionTable) getOrCreate(id string, create func() (*session, error)) (*session, bool, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if sess, ok := t.sessions[id]; ok {
+		return sess, false, nil
+	}
+	sess, err := create()
+	if err != nil {
+		return nil, false, err
+	}
+	t.sessions[id] = sess
+	return sess, true, nil
+}
+
+// BenchmarkSessionTable simulates many clients sending (lookup) and polling (get or create) concurrently
+// on 10k open sessions, comparing the sharded table with a single mutex guarded map.
+func BenchmarkSessionTable(b *testing.B) {
+	const sessions = 10000
+	ids := make([]string, sessions)
+	sess := newTestSession()
+	create := func() (*session, error) { return sess, nil }
+	tables := []struct {
+		name  string
+		table interface {
+			get(string) (*session, bool)
+			getOrCreate(string, func() (*session, error)) (*session, bool, error)
+		}
+	}{
+		{"mutex", &mutexSessionTable{sessions: make(map[string]*session)}},
+		{"sharded", new(sessionTable)},
+	}
+	for i := range ids {
+		ids[i] = fmt.Sprintf("session-%d", i)
+	}
+	for _, tc := range tables {
+		for _, id := range ids {
+			_, _, _ = tc.table.getOrCreate(id, create)
+		}
+		b.Run(tc.name, func(b *testing.B) {
+			var workers atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				n := workers.Add(1) * 7919 // every worker walks sessions from a different position
+				for pb.Next() {
+					n++
+					id := ids[n%sessions]
+					if n%4 == 0 { // poller
+						_, _, _ = tc.table.getOrCreate(id, create)
+					} else { // sender
+						_, _ = tc.table.get(id)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkSessionChurn creates and closes sessions from many goroutines through the handler,
+// including admission, without and with limits that need counting sessions per client address
+func BenchmarkSessionChurn(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		options Options
+	}{
+		{"no limits", Options{}},
+		{"max sessions", Options{MaxSessions: math.MaxInt32}},
+		{"max sessions per ip", Options{MaxSessionsPerIP: math.MaxInt32}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			tc.options.DisconnectDelay = time.Hour
+			h := NewHandler("", tc.options, nil)
+			req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			var workers atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				worker := workers.Add(1)
+				for n := 0; pb.Next(); n++ {
+					sess, err := h.sessionByRequest(req, fmt.Sprintf("session-%d-%d", worker, n))
+					if err != nil {
+						b.Fatal(err)
+					}
+					sess.close()
+				}
+			})
+		})
 	}
 }
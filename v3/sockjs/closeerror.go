@@ -0,0 +1,89 @@
+package sockjs
+
+import "fmt"
+
+// CloseCause tells why a session was closed
+type CloseCause int
+
+const (
+	// CloseCauseTimeout means no receiver was attached to the session for DisconnectDelay
+	CloseCauseTimeout CloseCause = iota + 1
+	// CloseCauseClientDisconnect means the client went away, i.e. the connection dropped or the receiver was interrupted
+	CloseCauseClientDisconnect
+	// CloseCauseServerClose means the session was closed by the server, i.e. by calling Session.Close
+	CloseCauseServerClose
+	// CloseCauseReceiverConflict means a receiving connection (i.e. xhr_streaming request) was closed with
+	// c[2010,"Another connection still open"] because another one is attached to the session, see
+	// ReceiverConflictPolicy. Only the connection is closed, the session stays open.
+	CloseCauseReceiverConflict
+	// CloseCauseShutdown means the session was closed because the handler is shutting down
+	CloseCauseShutdown
+	// CloseCauseIdleTimeout means no message was received from the client for Options.IdleTimeout
+	CloseCauseIdleTimeout
+	// CloseCauseLifetimeExceeded means the session reached Options.MaxSessionLifetime, or the lifetime set by
+	// Session.ExtendLifetime
+	CloseCauseLifetimeExceeded
+)
+
+func (c CloseCause) String() string {
+	switch c {
+	case CloseCauseTimeout:
+		return "timeout"
+	case CloseCauseClientDisconnect:
+		return "client disconnect"
+	case CloseCauseServerClose:
+		return "server close"
+	case CloseCauseReceiverConflict:
+		return "receiver conflict"
+	case CloseCauseShutdown:
+		return "shutdown"
+	case CloseCauseIdleTimeout:
+		return "idle timeout"
+	case CloseCauseLifetimeExceeded:
+		return "lifetime exceeded"
+	default:
+		return fmt.Sprintf("CloseCause(%d)", int(c))
+	}
+}
+
+// ClientClose describes how the client closed its websocket connection.
+type ClientClose struct {
+	// Code and Reason sent by the client in its close frame. Code is 1006 (abnormal closure) if the connection
+	// ended without a close frame.
+	Code   int
+	Reason string
+	// Clean is true if the close handshake completed, i.e. the client sent a close frame.
+	Clean bool
+}
+
+// CloseError is returned by session operations once the session is closed. It tells why the session ended.
+// CloseError matches ErrSessionNotOpen, use errors.Is(err, ErrSessionNotOpen) to check whether the session is closed.
+type CloseError struct {
+	// Code and Reason of the close frame sent to the client, or received from the client for CloseCauseClientDisconnect.
+	// Both are zero if the session ended without a close frame.
+	Code   uint32
+	Reason string
+	Cause  CloseCause
+	// Err is the underlying error that closed the session, if any (i.e. failed write)
+	Err error
+}
+
+func (e *CloseError) Error() string {
+	closed := "session"
+	if e.Cause == CloseCauseReceiverConflict {
+		closed = "receiver" // the session stays open
+	}
+	msg := fmt.Sprintf("sockjs: %s closed (%s)", closed, e.Cause)
+	if e.Code != 0 || e.Reason != "" {
+		msg += fmt.Sprintf(": %d %s", e.Code, e.Reason)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is ErrSessionNotOpen
+func (e *CloseError) Is(target error) bool { return target == ErrSessionNotOpen }
+
+func (e *CloseError) Unwrap() error { return e.Err }
@@ -0,0 +1,36 @@
+package sockjs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCloseError(t *testing.T) {
+	writeErr := errors.New("broken pipe")
+	cases := []struct {
+		err      *CloseError
+		expected string
+	}{
+		{&CloseError{Cause: CloseCauseTimeout}, "sockjs: session closed (timeout)"},
+		{&CloseError{Code: 3000, Reason: "bye", Cause: CloseCauseServerClose}, "sockjs: session closed (server close): 3000 bye"},
+		{&CloseError{Cause: CloseCauseClientDisconnect, Err: writeErr}, "sockjs: session closed (client disconnect): broken pipe"},
+		{errSessionReceiverAttached, "sockjs: receiver closed (receiver conflict): 2010 Another connection still open"},
+	}
+	for _, c := range cases {
+		if c.err.Error() != c.expected {
+			t.Errorf("Unexpected error message, got '%s' expected '%s'", c.err.Error(), c.expected)
+		}
+		if !errors.Is(c.err, ErrSessionNotOpen) {
+			t.Errorf("CloseError should match ErrSessionNotOpen")
+		}
+	}
+	if cFrame != `c[2010,"Another connection still open"]` {
+		t.Errorf("Unexpected receiver conflict close frame '%s'", cFrame)
+	}
+	if !errors.Is(cases[2].err, writeErr) {
+		t.Errorf("CloseError should unwrap underlying error")
+	}
+	if CloseCause(42).String() != "CloseCause(42)" {
+		t.Errorf("Unexpected unknown cause name '%s'", CloseCause(42))
+	}
+}
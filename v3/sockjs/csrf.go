@@ -0,0 +1,75 @@
+package sockjs
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// csrfTokenParam is the query parameter carrying CSRF token of the session on send requests
+	csrfTokenParam = "csrf"
+	// csrfTokenHeader is the header carrying CSRF token of the session on send requests
+	csrfTokenHeader = "X-CSRF-Token"
+)
+
+func newCSRFToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("sockjs: unable to generate CSRF token: " + err.Error())
+	}
+	return fmt.Sprintf("%x", b[:])
+}
+
+// checkCSRFToken reports whether the send request carries CSRF token of the session, always true
+// for sessions without token (Options.CSRFTokens disabled)
+func checkCSRFToken(sess *session, req *http.Request) bool {
+	if sess.csrfToken == "" {
+		return true
+	}
+	token := req.Header.Get(csrfTokenHeader)
+	if token == "" {
+		token = req.URL.Query().Get(csrfTokenParam)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(sess.csrfToken)) == 1
+}
+
+// sendOriginAllowed checks the origin of a send request when Options.StrictSendOrigin is set. The origin
+// is taken from Origin header, or from Referer if the former is missing. The request is allowed if the origin
+// is the same as the host the request was sent to, equals Options.Origin, or Options.CheckOrigin accepts it.
+func (h *Handler) sendOriginAllowed(req *http.Request) bool {
+	if !h.options.StrictSendOrigin {
+		return true
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = ""
+		if ref, err := url.Parse(req.Header.Get("Referer")); err == nil && ref.Host != "" {
+			origin = ref.Scheme + "://" + ref.Host
+		}
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	if h.options.Origin != "" && h.options.Origin != "*" && strings.EqualFold(origin, h.options.Origin) {
+		return true
+	}
+	return h.options.CheckOrigin != nil && h.options.CheckOrigin(req)
+}
+
+// securityHeaders sets Content-Security-Policy on responses rendering HTML (iframe and htmlfile)
+func (h *Handler) securityHeaders(rw http.ResponseWriter) {
+	if h.options.ContentSecurityPolicy != "" {
+		rw.Header().Set("Content-Security-Policy", h.options.ContentSecurityPolicy)
+	}
+}
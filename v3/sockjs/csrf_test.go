@@ -0,0 +1,122 @@
+package sockjs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_SendOriginAllowed(t *testing.T) {
+	h := newTestHandler()
+	h.options.StrictSendOrigin = true
+	h.options.Origin = "https://app.example.com"
+	cases := []struct {
+		origin, referer string
+		allowed         bool
+	}{
+		{"https://server.example.com", "", true},
+		{"https://app.example.com", "", true},
+		{"", "https://server.example.com/page?x=1", true},
+		{"null", "https://app.example.com/", true},
+		{"https://evil.example.org", "", false},
+		{"", "https://evil.example.org/", false},
+		{"null", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("POST", "https://server.example.com/server/session/jsonp_send", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.referer != "" {
+			req.Header.Set("Referer", c.referer)
+		}
+		if allowed := h.sendOriginAllowed(req); allowed != c.allowed {
+			t.Errorf("Unexpected result for origin '%s' referer '%s', got %v expected %v", c.origin, c.referer, allowed, c.allowed)
+		}
+	}
+	h.options.CheckOrigin = func(req *http.Request) bool { return req.Header.Get("Origin") == "https://evil.example.org" }
+	req, _ := http.NewRequest("POST", "https://server.example.com/server/session/jsonp_send", nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	if !h.sendOriginAllowed(req) {
+		t.Errorf("Origin accepted by CheckOrigin should be allowed")
+	}
+}
+
+func TestHandler_jsonpSendForeignOrigin(t *testing.T) {
+	h := newTestHandler()
+	h.options.StrictSendOrigin = true
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/session/jsonp_send", strings.NewReader("d=%5B%22message%22%5D"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.org")
+	storeTestSession(h, "session", newSession(req, "session", time.Second, time.Second))
+	withSessionID(h.jsonpSend)(rw, req)
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("Wrong response status received %d, should be %d", rw.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandler_SendCSRFToken(t *testing.T) {
+	h := newTestHandler()
+	h.options.CSRFTokens = true
+	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+	sess := h.createSession(req, "session")
+	storeTestSession(h, "session", sess)
+	if len(sess.CSRFToken()) != 32 {
+		t.Fatalf("Session should get a CSRF token, got '%s'", sess.CSRFToken())
+	}
+	go func() {
+		for {
+			if _, err := sess.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	defer sess.close()
+
+	for _, send := range []sessionHandlerFunc{h.xhrSend, h.jsonpSend} {
+		for _, c := range []struct {
+			url    string
+			header string
+			code   int
+		}{
+			{"/server/session/send", "", http.StatusForbidden},
+			{"/server/session/send?csrf=wrong", "", http.StatusForbidden},
+			{"/server/session/send?csrf=" + sess.CSRFToken(), "", 0},
+			{"/server/session/send", sess.CSRFToken(), 0},
+		} {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", c.url, strings.NewReader(`["message"]`))
+			if c.header != "" {
+				req.Header.Set("X-CSRF-Token", c.header)
+			}
+			send(rw, req, "session")
+			if forbidden := rw.Code == http.StatusForbidden; forbidden != (c.code == http.StatusForbidden) {
+				t.Errorf("Unexpected response status %d for '%s' with header '%s'", rw.Code, c.url, c.header)
+			}
+		}
+	}
+}
+
+func TestHandler_SecurityHeaders(t *testing.T) {
+	h := NewHandler("", DefaultOptions, nil)
+	h.options.ContentSecurityPolicy = "default-src 'self'"
+	for _, path := range []string{"/iframe.html", "/server/session/htmlfile?c=cb", "/info"} {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		ctx, cancel := context.WithCancel(req.Context())
+		cancel() // do not keep streaming htmlfile response open
+		h.ServeHTTP(rw, req.WithContext(ctx))
+		if got := rw.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("Unexpected X-Content-Type-Options for '%s', got '%s'", path, got)
+		}
+		csp := rw.Header().Get("Content-Security-Policy")
+		if expected := map[bool]string{true: "default-src 'self'"}[path != "/info"]; csp != expected {
+			t.Errorf("Unexpected Content-Security-Policy for '%s', got '%s' expected '%s'", path, csp, expected)
+		}
+	}
+}
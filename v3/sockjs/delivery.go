@@ -0,0 +1,109 @@
+package sockjs
+
+import "context"
+
+// SendConflated sends one text frame to session like Send. If a frame sent with the same key is still
+// waiting in the send buffer (no receiver is attached, or coalescing window did not pass yet), it is replaced
+// in place by msg instead of queueing msg again, i.e. live feeds buffer only the latest value per key.
+// Options of the replaced frame are replaced too, SendCtx waiting for the replaced frame returns once
+// the replacement is written.
+func (s *session) SendConflated(key, msg string, opts ...SendOption) error {
+	meta := newMessageMeta(opts)
+	if meta == nil {
+		meta = new(messageMeta)
+	}
+	meta.key = key
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.sendMessageLocked(msg, false, meta)
+}
+
+// SendCtx sends one text frame to session like Send, and waits until the frame is written to a receiver
+// attached to the session (for polling transports that may take until the client polls). Coalescing window
+// (see Options.SendCoalesceWindow) does not delay it. If ctx is done first the ctx error is returned and
+// the frame stays queued. If the session gets closed before the frame is written the session's error is returned,
+// ErrMessageExpired if the frame was dropped because of its time-to-live.
+func (s *session) SendCtx(ctx context.Context, msg string, opts ...SendOption) error {
+	meta := newMessageMeta(opts)
+	var status *sendStatus
+	if meta != nil && !meta.expires.IsZero() {
+		status = new(sendStatus)
+		meta.status = status
+	}
+	s.mux.Lock()
+	if err := s.sendMessageLocked(msg, true, meta); err != nil {
+		s.mux.Unlock()
+		return err
+	}
+	seq := s.sendSeq
+	s.mux.Unlock()
+	return s.waitDelivered(ctx, seq, status)
+}
+
+// Flush waits until all frames sent so far are written to a receiver (or dropped because of their time-to-live),
+// frames waiting for coalescing window are written right away. Errors are reported like by SendCtx. Sending
+// a final message and calling Flush before Close makes sure the client gets the message.
+func (s *session) Flush(ctx context.Context) error {
+	s.mux.Lock()
+	seq := s.sendSeq
+	s.flushLocked()
+	s.mux.Unlock()
+	return s.waitDelivered(ctx, seq, nil)
+}
+
+// waitDelivered waits until messages up to seq are delivered. The send buffer is always written as a whole
+// so writing it delivers all messages sent so far. It gives up once the session is closing and no message
+// is still being written.
+func (s *session) waitDelivered(ctx context.Context, seq uint64, status *sendStatus) error {
+	s.mux.Lock()
+	for {
+		if s.deliveredSeq >= seq {
+			expired := status != nil && status.expired
+			s.mux.Unlock()
+			if expired {
+				return ErrMessageExpired
+			}
+			return nil
+		}
+		if s.state >= SessionClosing && s.inflight == 0 {
+			err := s.errLocked()
+			s.mux.Unlock()
+			return err
+		}
+		if s.deliveryCh == nil {
+			s.deliveryCh = make(chan struct{})
+		}
+		progress := s.deliveryCh
+		s.mux.Unlock()
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mux.Lock()
+	}
+}
+
+// settle records messages written by async receiver. Messages it returned unsent are put back to the send
+// buffer to be sent by the next receiver, like on takeover.
+func (s *session) settle(written int, seq uint64, unsent messageBatch) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.inflight -= uint64(written)
+	if len(unsent.messages) > 0 {
+		s.requeueLocked(unsent)
+		s.flushLocked() // in case the next receiver is attached already
+	}
+	s.deliveredLocked(seq)
+}
+
+// deliveredLocked records that messages up to seq are delivered and wakes up waiters of SendCtx and Flush
+func (s *session) deliveredLocked(seq uint64) {
+	if seq > s.deliveredSeq {
+		s.deliveredSeq = seq
+	}
+	if s.deliveryCh != nil {
+		close(s.deliveryCh)
+		s.deliveryCh = nil
+	}
+}
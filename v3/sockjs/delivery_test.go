@@ -0,0 +1,155 @@
+package sockjs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_SendCtxWaitsForReceiver(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	recv := newTestReceiver()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = sess.attachReceiver(recv)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sess.SendCtx(ctx, "message"))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"o", "message"}, recv.frames)
+}
+
+func TestSession_SendCtxContextDone(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sess.SendCtx(ctx, "message"))
+	assert.Equal(t, []string{"message"}, sess.sendBuffer, "message should stay queued")
+}
+
+func TestSession_SendCtxSessionClosed(t *testing.T) {
+	sess := newTestSession()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = sess.Close(3000, "bye")
+	}()
+	err := sess.SendCtx(context.Background(), "message")
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr), "unexpected error '%v'", err)
+	assert.Equal(t, uint32(3000), closeErr.Code)
+	assert.Error(t, sess.SendCtx(context.Background(), "after close"))
+}
+
+func TestSession_FlushSkipsCoalesceWindow(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.sendCoalesceWindow = time.Hour
+	recv := newTestReceiver()
+	require.NoError(t, sess.attachReceiver(recv))
+	require.NoError(t, sess.Send("a"))
+	require.NoError(t, sess.Send("b"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sess.Flush(ctx))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"o", "a", "b"}, recv.frames)
+}
+
+func TestSession_FlushEmpty(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	assert.NoError(t, sess.Flush(context.Background()))
+}
+
+func TestSession_SendCtxAsyncWriter(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.asyncWriter = true
+	inner := newBlockingReceiver(nil)
+	require.NoError(t, sess.attachReceiver(inner))
+
+	done := make(chan error, 1)
+	go func() { done <- sess.SendCtx(context.Background(), "message") }()
+	select {
+	case err := <-done:
+		t.Fatalf("SendCtx should wait for the async writer, returned '%v'", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(inner.release)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("SendCtx should return once the message is written")
+	}
+	inner.Lock()
+	defer inner.Unlock()
+	assert.Equal(t, []string{"o", "message"}, inner.frames)
+}
+
+func TestSession_SendCtxAsyncWriterFails(t *testing.T) {
+	sess := newTestSession()
+	inner := newBlockingReceiver(errors.New("broken pipe"))
+	sess.asyncWriter = true
+	require.NoError(t, sess.attachReceiver(inner))
+
+	done := make(chan error, 1)
+	go func() { done <- sess.SendCtx(context.Background(), "message") }()
+	close(inner.release)
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+		assert.Equal(t, SessionClosed, sess.GetSessionState())
+	case <-time.After(time.Second):
+		t.Fatal("SendCtx should fail once the async writer fails")
+	}
+}
+
+func TestSession_SendCtxExpiredAfterRequeue(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.asyncWriter = true
+	sess.conflictPolicy = ReceiverConflictTakeover
+	old := newBlockingReceiver(nil)
+	defer close(old.release)
+	require.NoError(t, sess.attachReceiver(old))
+	<-old.writing
+
+	done := make(chan error, 1)
+	go func() { done <- sess.SendCtx(context.Background(), "stale", WithTTL(10*time.Millisecond)) }()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, sess.attachReceiver(newTestReceiver()))
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrMessageExpired, err)
+	case <-time.After(time.Second):
+		t.Fatal("SendCtx should fail once its requeued message expires")
+	}
+}
+
+func TestSession_FlushAfterReceiverEndedWithExpiredMessages(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.asyncWriter = true
+	inner := newBlockingReceiver(nil)
+	require.NoError(t, sess.attachReceiver(inner))
+	<-inner.writing // writer is stuck on the open frame
+	require.NoError(t, sess.recv.sendFrame("h"))
+	// expires before it is written, so the writer gets only its delivery sequence
+	require.NoError(t, sess.Send("stale", WithTTL(-time.Second)))
+	inner.close() // the heartbeat fails with the receiver ended
+	close(inner.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, sess.Flush(ctx), "dropped delivery sequence should be settled")
+}
@@ -0,0 +1,159 @@
+package sockjs
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+)
+
+// EventHandler receives session events as callbacks, see NewEventHandler. Callbacks are called from
+// the goroutines serving transport requests, so they should not block for long.
+//
+// Callbacks of one session are never called concurrently and are called in order: OnOpen first,
+// then OnMessage for every message in the order it was received, and OnClose last. OnClose is called
+// only for sessions that were opened. Callbacks may call any Session method, including Close.
+type EventHandler interface {
+	// OnOpen is called once the session is opened
+	OnOpen(Session)
+	// OnMessage is called for every message received from the client
+	OnMessage(Session, string)
+	// OnClose is called once the session is closed, err is the *CloseError telling why
+	OnClose(Session, error)
+}
+
+// NewEventHandler creates new HTTP handler that delivers session events to events instead of running
+// a handler function in a goroutine per session. Messages are handed to OnMessage by the request that
+// received them, Session.Recv is not used with event handlers. If OnMessage falls behind, requests receiving
+// further messages of the session wait until it catches up, like with handler functions not calling Recv.
+func NewEventHandler(prefix string, opts Options, events EventHandler) *Handler {
+	h := NewHandler(prefix, opts, nil)
+	h.events = events
+	return h
+}
+
+type sessionEventKind int
+
+const (
+	eventOpen sessionEventKind = iota
+	eventMessage
+	eventClose
+)
+
+type sessionEvent struct {
+	kind sessionEventKind
+	msg  string
+}
+
+// maxQueuedMessages is the number of messages queued for a session before receiving more blocks
+const maxQueuedMessages = 256
+
+// sessionEvents serializes event callbacks of a session without a goroutine of its own. Events are queued
+// and the caller that finds the queue idle delivers them until the queue is empty, others return right away.
+// Events queued from within a callback (i.e. close) are delivered after the callback returns.
+// Callers queueing messages wait while maxQueuedMessages are queued and another caller delivers them.
+type sessionEvents struct {
+	handler EventHandler
+	h       *Handler
+
+	mux     sync.Mutex
+	dequeue *sync.Cond // signalled once an event is taken from the queue or delivery stops
+	queue   []sessionEvent
+	running bool
+	closing bool // close event was queued, messages are not accepted anymore
+	opened  bool
+	closed  bool
+}
+
+// useEvents makes the session deliver events to the handler's EventHandler
+func (h *Handler) useEvents(sess *session) {
+	e := &sessionEvents{handler: h.events, h: h}
+	e.dequeue = sync.NewCond(&e.mux)
+	sess.events = e
+	context.AfterFunc(sess.context, func() { e.dispatch(sess, sessionEvent{kind: eventClose}) })
+}
+
+// messages delivers messages received from the client, it fails if the session is not open
+func (e *sessionEvents) messages(sess *session, messages []string) error {
+	sess.mux.RLock()
+	if sess.state >= SessionClosing {
+		err := sess.errLocked()
+		sess.mux.RUnlock()
+		return err
+	}
+	sess.mux.RUnlock()
+	e.mux.Lock()
+	for _, msg := range messages {
+		for e.running && !e.closing && len(e.queue) >= maxQueuedMessages {
+			e.dequeue.Wait()
+		}
+		if e.closing {
+			e.mux.Unlock()
+			return sess.Err()
+		}
+		e.queue = append(e.queue, sessionEvent{kind: eventMessage, msg: msg})
+	}
+	e.runLocked(sess)
+	return nil
+}
+
+func (e *sessionEvents) dispatch(sess *session, event sessionEvent) {
+	e.mux.Lock()
+	if event.kind == eventClose {
+		e.closing = true
+		e.dequeue.Broadcast()
+	}
+	e.queue = append(e.queue, event)
+	e.runLocked(sess)
+}
+
+// runLocked delivers queued events unless another caller does, it unlocks e.mux
+func (e *sessionEvents) runLocked(sess *session) {
+	if e.running {
+		e.mux.Unlock()
+		return
+	}
+	e.running = true
+	for len(e.queue) > 0 {
+		event := e.queue[0]
+		e.queue[0] = sessionEvent{}
+		e.queue = e.queue[1:]
+		if len(e.queue) == 0 {
+			e.queue = nil // idle sessions do not retain the queue
+		}
+		e.dequeue.Broadcast()
+		e.mux.Unlock()
+		e.deliver(sess, event)
+		e.mux.Lock()
+	}
+	e.running = false
+	e.dequeue.Broadcast()
+	e.mux.Unlock()
+}
+
+// deliver calls the callback of the event, a panic closes the session like a panic of a handler function
+func (e *sessionEvents) deliver(sess *session, event sessionEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.h.recoverHandler(sess, r, debug.Stack())
+		}
+	}()
+	// flags are only accessed by the delivering caller
+	switch {
+	case e.closed:
+	case event.kind == eventClose:
+		e.closed = true
+		if e.opened {
+			e.handler.OnClose(sess, sess.Err())
+		}
+	case event.kind == eventOpen && sess.Err() != nil:
+		// closed before it was opened, its close event is on the way
+	case !e.opened:
+		e.opened = true
+		e.handler.OnOpen(sess)
+		if event.kind == eventMessage { // message raced ahead of the open event
+			e.handler.OnMessage(sess, event.msg)
+		}
+	case event.kind == eventMessage:
+		e.handler.OnMessage(sess, event.msg)
+	}
+}
@@ -0,0 +1,279 @@
+package sockjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingEvents records callbacks as "open", "message:<msg>" and "close:<err>" and fails the test
+// if callbacks of a session overlap
+type recordingEvents struct {
+	t         *testing.T
+	onMessage func(Session, string)
+	active    atomic.Int32
+	mux       sync.Mutex
+	events    []string
+	closed    chan struct{}
+}
+
+func newRecordingEvents(t *testing.T) *recordingEvents {
+	return &recordingEvents{t: t, closed: make(chan struct{})}
+}
+
+func (r *recordingEvents) record(event string) {
+	if r.active.Add(1) != 1 {
+		r.t.Errorf("Callbacks called concurrently")
+	}
+	defer r.active.Add(-1)
+	r.mux.Lock()
+	r.events = append(r.events, event)
+	r.mux.Unlock()
+}
+
+func (r *recordingEvents) OnOpen(s Session) { r.record("open") }
+
+func (r *recordingEvents) OnMessage(s Session, msg string) {
+	r.record("message:" + msg)
+	if r.onMessage != nil {
+		r.onMessage(s, msg)
+	}
+}
+
+func (r *recordingEvents) OnClose(s Session, err error) {
+	r.record(fmt.Sprintf("close:%v", err))
+	close(r.closed)
+}
+
+func (r *recordingEvents) recorded() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordingEvents) waitClosed(t *testing.T) {
+	select {
+	case <-r.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("OnClose not called, got %v", r.recorded())
+	}
+}
+
+func newTestEventHandler(events EventHandler) *Handler {
+	h := newTestHandler()
+	h.events = events
+	return h
+}
+
+func TestEventHandler_WebSocket(t *testing.T) {
+	events := newRecordingEvents(t)
+	events.onMessage = func(s Session, msg string) { _ = s.Send("echo " + msg) }
+	h := NewEventHandler("/echo", testOptions, events)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/echo/server/session/websocket", nil)
+	require.NoError(t, err)
+	_, p, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "o", string(p))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`["a","b"]`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`["c"]`)))
+	var echoed []string
+	for len(echoed) < 3 {
+		_, p, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var frame []string
+		require.NoError(t, json.Unmarshal(p[1:], &frame))
+		echoed = append(echoed, frame...)
+	}
+	assert.Equal(t, []string{"echo a", "echo b", "echo c"}, echoed)
+	_ = conn.Close()
+
+	events.waitClosed(t)
+	recorded := events.recorded()
+	assert.Equal(t, []string{"open", "message:a", "message:b", "message:c"}, recorded[:4])
+	assert.True(t, strings.HasPrefix(recorded[4], "close:"), recorded[4])
+}
+
+func TestEventHandler_ConcurrentSends(t *testing.T) {
+	events := newRecordingEvents(t)
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	h.startHandler(sess)
+
+	const senders, messages = 4, 100
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				assert.NoError(t, sess.accept(fmt.Sprintf("%d-%d", i, j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	sess.close()
+	events.waitClosed(t)
+
+	recorded := events.recorded()
+	require.Len(t, recorded, 2+senders*messages)
+	assert.Equal(t, "open", recorded[0])
+	next := make([]int, senders)
+	for _, event := range recorded[1 : len(recorded)-1] {
+		var i, j int
+		_, err := fmt.Sscanf(event, "message:%d-%d", &i, &j)
+		require.NoError(t, err)
+		assert.Equal(t, next[i], j, "messages of a sender out of order")
+		next[i]++
+	}
+}
+
+func TestEventHandler_CloseFromCallback(t *testing.T) {
+	events := newRecordingEvents(t)
+	events.onMessage = func(s Session, msg string) { _ = s.Close(3000, "bye") }
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	h.startHandler(sess)
+
+	require.NoError(t, sess.accept("first"))
+	events.waitClosed(t)
+	assert.True(t, errors.Is(sess.accept("second"), ErrSessionNotOpen))
+	assert.Equal(t, []string{"open", "message:first", "close:" + sess.Err().Error()}, events.recorded())
+}
+
+func TestEventHandler_MessageBeforeOpen(t *testing.T) {
+	events := newRecordingEvents(t)
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+
+	require.NoError(t, sess.accept("early"))
+	h.startHandler(sess)
+	sess.close()
+	events.waitClosed(t)
+	assert.Equal(t, []string{"open", "message:early"}, events.recorded()[:2])
+}
+
+func TestEventHandler_NotOpenedNotClosed(t *testing.T) {
+	events := newRecordingEvents(t)
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	sess.close()
+	h.startHandler(sess)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, events.recorded())
+}
+
+func TestEventHandler_Panic(t *testing.T) {
+	events := newRecordingEvents(t)
+	events.onMessage = func(s Session, msg string) { panic("boom") }
+	h := newTestEventHandler(events)
+	h.options.OnHandlerPanic = func(Session, interface{}, []byte) {}
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	h.startHandler(sess)
+
+	require.NoError(t, sess.accept("message"))
+	events.waitClosed(t)
+	var closeErr *CloseError
+	require.True(t, errors.As(sess.Err(), &closeErr))
+	assert.Equal(t, uint32(handlerPanicCloseCode), closeErr.Code)
+	assert.Equal(t, uint64(1), h.Stats().HandlerPanics)
+}
+
+// waitQueuedEvents waits until n events are queued for delivery
+func waitQueuedEvents(t *testing.T, sess *session, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		sess.events.mux.Lock()
+		queued := len(sess.events.queue)
+		sess.events.mux.Unlock()
+		if queued == n {
+			return
+		}
+		require.True(t, time.Now().Before(deadline), "expected %d queued events, got %d", n, queued)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventHandler_SlowOnMessageBlocksReceiving(t *testing.T) {
+	events := newRecordingEvents(t)
+	release, delivering := make(chan struct{}), make(chan struct{})
+	events.onMessage = func(s Session, msg string) {
+		if msg == "first" {
+			close(delivering)
+			<-release
+		}
+	}
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	defer sess.close()
+	h.startHandler(sess)
+
+	go func() { _ = sess.accept("first") }()
+	<-delivering // the request of the first message delivers and blocks in OnMessage
+	more := make([]string, maxQueuedMessages+1)
+	for i := range more {
+		more[i] = fmt.Sprintf("%d", i)
+	}
+	accepted := make(chan error, 1)
+	go func() { accepted <- sess.accept(more...) }()
+
+	waitQueuedEvents(t, sess, maxQueuedMessages)
+	select {
+	case err := <-accepted:
+		t.Fatalf("Receiving should wait for the slow callback, returned '%v'", err)
+	default:
+	}
+
+	close(release)
+	select {
+	case err := <-accepted:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Receiving should continue once the callback catches up")
+	}
+	recorded := events.recorded()
+	require.Len(t, recorded, len(more)+2)
+	assert.Equal(t, "message:first", recorded[1])
+	assert.Equal(t, fmt.Sprintf("message:%d", maxQueuedMessages), recorded[len(recorded)-1])
+}
+
+func TestEventHandler_CloseReleasesBlockedReceiving(t *testing.T) {
+	events := newRecordingEvents(t)
+	release, delivering := make(chan struct{}), make(chan struct{}, 1)
+	defer close(release)
+	events.onMessage = func(s Session, msg string) {
+		select {
+		case delivering <- struct{}{}:
+		default:
+		}
+		<-release
+	}
+	h := newTestEventHandler(events)
+	sess := h.createSession(httptest.NewRequest("POST", "/server/session/xhr", nil), "session")
+	h.startHandler(sess)
+
+	go func() { _ = sess.accept("first") }()
+	<-delivering
+	accepted := make(chan error, 1)
+	go func() { accepted <- sess.accept(make([]string, maxQueuedMessages+1)...) }()
+	waitQueuedEvents(t, sess, maxQueuedMessages)
+	sess.close()
+	select {
+	case err := <-accepted:
+		assert.True(t, errors.Is(err, ErrSessionNotOpen), "unexpected error '%v'", err)
+	case <-time.After(time.Second):
+		t.Fatal("Closing the session should release receiving")
+	}
+}
@@ -4,19 +4,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
 )
 
-func (h *Handler) eventSource(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) eventSource(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	rw.Header().Set("content-type", "text/event-stream; charset=UTF-8")
 	_, _ = fmt.Fprint(rw, "\r\n")
 	rw.(http.Flusher).Flush()
 
 	recv := newHTTPReceiver(rw, req, h.options.ResponseLimit, new(eventSourceFrameWriter), ReceiverTypeEventSource)
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, sessionID)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		sessionError(rw, new(eventSourceFrameWriter), err)
 		return
 	}
 	if err := sess.attachReceiver(recv); err != nil {
@@ -27,22 +25,31 @@ func (h *Handler) eventSource(rw http.ResponseWriter, req *http.Request) {
 		recv.close()
 		return
 	}
-	sess.startHandlerOnce.Do(func() { go h.handlerFunc(Session{sess}) })
-	select {
-	case <-recv.doneNotify():
-	case <-recv.interruptedNotify():
-	}
+	h.startHandler(sess)
+	sess.serveReceiver(recv)
 }
 
 type eventSourceFrameWriter struct{}
 
-var escaper *strings.Replacer = strings.NewReplacer(
-	"%", url.QueryEscape("%"),
-	"\n", url.QueryEscape("\n"),
-	"\r", url.QueryEscape("\r"),
-	"\x00", url.QueryEscape("\x00"),
-)
+func (*eventSourceFrameWriter) write(w io.Writer, frame []byte) (int, error) {
+	fb := getFrameBuffer()
+	defer putFrameBuffer(fb)
+	fb.b = append(fb.b, "data: "...)
+	fb.b = appendEventSourceData(fb.b, frame)
+	fb.b = append(fb.b, "\r\n\r\n"...)
+	return w.Write(fb.b)
+}
 
-func (*eventSourceFrameWriter) write(w io.Writer, frame string) (int, error) {
-	return fmt.Fprintf(w, "data: %s\r\n\r\n", escaper.Replace(frame))
+// appendEventSourceData appends frame to dst with %, line breaks and NUL percent-encoded, like url.QueryEscape does
+func appendEventSourceData(dst, frame []byte) []byte {
+	start := 0
+	for i, b := range frame {
+		if b != '%' && b != '\n' && b != '\r' && b != 0 {
+			continue
+		}
+		dst = append(dst, frame[start:i]...)
+		dst = append(dst, '%', "0123456789ABCDEF"[b>>4], "0123456789ABCDEF"[b&0xf])
+		start = i + 1
+	}
+	return append(dst, frame[start:]...)
 }
@@ -3,6 +3,7 @@ package sockjs_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -40,7 +41,7 @@ func (s *eventSourceStage) a_new_sockjs_handler_is_created() *eventSourceStage {
 		close(s.haveSession)
 		for {
 			msg, err := sess.Recv()
-			if err == sockjs.ErrSessionNotOpen {
+			if errors.Is(err, sockjs.ErrSessionNotOpen) {
 				return
 			}
 			require.NoError(s.t, err)
@@ -15,13 +15,13 @@ func TestHandler_EventSource(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/server/session/eventsource", nil)
 	h := newTestHandler()
 	h.options.ResponseLimit = 1024
+	// the receiver is closed by the test, not by the handler function returning
+	h.options.KeepSessionOnHandlerReturn = true
 	go func() {
 		var sess *session
 		for exists := false; !exists; {
 			runtime.Gosched()
-			h.sessionsMux.Lock()
-			sess, exists = h.sessions["session"]
-			h.sessionsMux.Unlock()
+			sess, exists = h.sessions.get("session")
 		}
 		for exists := false; !exists; {
 			runtime.Gosched()
@@ -36,7 +36,7 @@ func TestHandler_EventSource(t *testing.T) {
 		sess.recv.close()
 		sess.mux.RUnlock()
 	}()
-	h.eventSource(rw, req)
+	withSessionID(h.eventSource)(rw, req)
 
 	contentType := rw.Header().Get("content-type")
 	expected := "text/event-stream; charset=UTF-8"
@@ -54,34 +54,36 @@ func TestHandler_EventSource(t *testing.T) {
 
 func TestHandler_EventSourceMultipleConnections(t *testing.T) {
 	h := newTestHandler()
+	// the first receiver must stay attached, not be closed with the session once the handler function returns
+	h.options.KeepSessionOnHandlerReturn = true
 	h.options.ResponseLimit = 1024
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/sess/eventsource", nil)
 	go func() {
 		rw := httptest.NewRecorder()
-		h.eventSource(rw, req)
+		withSessionID(h.eventSource)(rw, req)
 		if rw.Body.String() != "\r\ndata: c[2010,\"Another connection still open\"]\r\n\r\n" {
 			t.Errorf("wrong, got '%v'", rw.Body)
 		}
-		h.sessionsMux.Lock()
-		sess := h.sessions["sess"]
+		sess, _ := h.sessions.get("sess")
 		sess.close()
-		h.sessionsMux.Unlock()
 	}()
-	h.eventSource(rw, req)
+	withSessionID(h.eventSource)(rw, req)
 }
 
 func TestHandler_EventSourceConnectionInterrupted(t *testing.T) {
 	h := newTestHandler()
+	// the session must be closed by the interrupted request
+	h.options.KeepSessionOnHandlerReturn = true
 	sess := newTestSession()
 	sess.state = SessionActive
-	h.sessions["session"] = sess
+	storeTestSession(h, "session", sess)
 	req, _ := http.NewRequest("POST", "/server/session/eventsource", nil)
 	ctx, cancel := context.WithCancel(req.Context())
 	req = req.WithContext(ctx)
 	rw := httptest.NewRecorder()
 	cancel()
-	h.eventSource(rw, req)
+	withSessionID(h.eventSource)(rw, req)
 	select {
 	case <-sess.closeCh:
 	case <-time.After(1 * time.Second):
@@ -99,7 +101,7 @@ func TestEventSourceFrameWriter(t *testing.T) {
 
 	// Confirm that "important" characters are escaped, but others pass
 	// through unmodified.
-	_, err := writer.write(out, "escaped: %\r\n;unescaped: +&#")
+	_, err := writer.write(out, []byte("escaped: %\r\n;unescaped: +&#"))
 	if err != nil {
 		t.Errorf("unexpected write error: %s", err)
 	}
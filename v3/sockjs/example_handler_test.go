@@ -1,6 +1,7 @@
 package sockjs_test
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/igm/sockjs-go/v3/sockjs"
@@ -37,3 +38,41 @@ func ExampleNewHandler_defaultMux() {
 	http.Handle("/echo/", handler)
 	_ = http.ListenAndServe(":8080", nil)
 }
+
+// loggingSession decorates a Session, methods it does not override are served by the embedded one
+type loggingSession struct {
+	sockjs.Session
+}
+
+func (s loggingSession) Send(msg string, opts ...sockjs.SendOption) error {
+	log.Printf("session %s: sending %q", s.ID(), msg)
+	return s.Session.Send(msg, opts...)
+}
+
+func echo(session sockjs.Session) {
+	for {
+		msg, err := session.Recv()
+		if err != nil || session.Send(msg) != nil {
+			return
+		}
+	}
+}
+
+func ExampleSession_decorator() {
+	handler := sockjs.NewHandler("/echo", sockjs.DefaultOptions, func(session sockjs.Session) {
+		echo(loggingSession{session})
+	})
+	_ = http.ListenAndServe(":8080", handler)
+}
+
+func ExampleSession_Err() {
+	handler := sockjs.NewHandler("/echo", sockjs.DefaultOptions, func(session sockjs.Session) {
+		for msg, err := range session.Messages(session.Context()) {
+			if err != nil || session.Send(msg) != nil {
+				break
+			}
+		}
+		log.Printf("session %s ended: %v", session.ID(), session.Err())
+	})
+	_ = http.ListenAndServe(":8080", handler)
+}
@@ -3,9 +3,53 @@ package sockjs
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"unsafe"
 )
 
+// maxPooledFrameSize limits the capacity of buffers returned to the frame pool,
+// so that a single huge message does not keep a large buffer alive forever.
+const maxPooledFrameSize = 64 * 1024
+
+type frameBuffer struct {
+	b []byte
+}
+
+var frameBufferPool = sync.Pool{
+	New: func() interface{} { return &frameBuffer{b: make([]byte, 0, 512)} },
+}
+
+func getFrameBuffer() *frameBuffer {
+	fb := frameBufferPool.Get().(*frameBuffer)
+	fb.b = fb.b[:0]
+	return fb
+}
+
+func putFrameBuffer(fb *frameBuffer) {
+	if cap(fb.b) <= maxPooledFrameSize {
+		frameBufferPool.Put(fb)
+	}
+}
+
+// frameString returns frame as string without copying it. The frame must not be modified
+// while the string is in use, frame writers only read it before the buffer goes back to the pool.
+func frameString(frame []byte) string {
+	return unsafe.String(unsafe.SliceData(frame), len(frame))
+}
+
 func closeFrame(status uint32, reason string) string {
 	bytes, _ := json.Marshal([]interface{}{status, reason})
 	return fmt.Sprintf("c%s", string(bytes))
 }
+
+// appendBulkFrame appends data frame in format a["msg 1","msg 2",...] to dst
+func appendBulkFrame(dst []byte, messages []string, escapeHTML bool) []byte {
+	dst = append(dst, 'a', '[')
+	for i, msg := range messages {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, msg, escapeHTML)
+	}
+	return append(dst, ']')
+}
@@ -8,3 +8,53 @@ func TestCloseFrame(t *testing.T) {
 		t.Errorf("Wrong close frame generated '%s'", cf)
 	}
 }
+
+func TestAppendBulkFrame(t *testing.T) {
+	frame := appendBulkFrame(nil, []string{"message 1", "quoted \"message\""}, true)
+	if string(frame) != `a["message 1","quoted \"message\""]` {
+		t.Errorf("Wrong bulk frame generated '%s'", frame)
+	}
+	fb := getFrameBuffer()
+	fb.b = appendBulkFrame(fb.b, []string{"reused"}, true)
+	if string(fb.b) != `a["reused"]` {
+		t.Errorf("Wrong bulk frame generated into pooled buffer '%s'", fb.b)
+	}
+	putFrameBuffer(fb)
+}
+
+type countingWriter struct {
+	writes int
+	out    []byte
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.out = append(w.out[:0], p...)
+	return len(p), nil
+}
+
+func TestFrameWriters_SingleWrite(t *testing.T) {
+	frame := []byte(`a["<msg>\n%"]`)
+	cases := []struct {
+		writer   frameWriter
+		expected string
+	}{
+		{new(xhrFrameWriter), "a[\"<msg>\\n%\"]\n"},
+		{new(eventSourceFrameWriter), "data: a[\"<msg>\\n%25\"]\r\n\r\n"},
+		{new(htmlfileFrameWriter), "<script>\np(\"a[\\\"\\u003cmsg\\u003e\\\\n%\\\"]\");\n</script>\r\n"},
+		{&jsonpFrameWriter{callback: "cb"}, "cb(\"a[\\\"\\u003cmsg\\u003e\\\\n%\\\"]\");\r\n"},
+	}
+	for _, c := range cases {
+		w := &countingWriter{out: make([]byte, 0, 128)}
+		if _, err := c.writer.write(w, frame); err != nil {
+			t.Fatalf("Unexpected write error: %v", err)
+		}
+		if w.writes != 1 || string(w.out) != c.expected {
+			t.Errorf("Unexpected output of %T in %d writes '%s'", c.writer, w.writes, w.out)
+		}
+		allocs := testing.AllocsPerRun(100, func() { _, _ = c.writer.write(w, frame) })
+		if allocs != 0 {
+			t.Errorf("Frame writer %T should write from pooled buffer, got %v allocations", c.writer, allocs)
+		}
+	}
+}
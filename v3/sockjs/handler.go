@@ -1,165 +1,291 @@
 package sockjs
 
 import (
+	"log"
 	"net/http"
 	"net/url"
-	"regexp"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type Handler struct {
 	prefix      string
 	options     Options
 	handlerFunc func(Session)
-	mappings    []*mapping
+	events      EventHandler // set by NewEventHandler, replaces handlerFunc
+	router      router
 
-	sessionsMux sync.Mutex
-	sessions    map[string]*session
+	trustedProxies trustedProxies
+
+	sessions sessionTable
+
+	openSessions     atomic.Int64
+	admissionMux     sync.Mutex // guards openSessionsByIP
+	openSessionsByIP map[string]int
+	shuttingDown     atomic.Bool
+
+	handlerPanics atomic.Uint64
+	timerWheel    *timerWheels // shared scheduler of session timers, nil if sessions use own timers
 }
 
-const sessionPrefix = "^/([^/.]+)/([^/.]+)"
+const (
+	defaultHandlerReturnCloseCode   = 3000
+	defaultHandlerReturnCloseReason = "Handler finished"
+	handlerPanicCloseCode           = 1011
+	handlerPanicCloseReason         = "Internal error"
+	shutdownCloseCode               = 1001
+	shutdownCloseReason             = "Server shutting down"
+	defaultIdleCloseCode            = 3001
+	defaultIdleCloseReason          = "Session idle"
+	defaultLifetimeCloseCode        = 3002
+	defaultLifetimeCloseReason      = "Session lifetime exceeded"
+)
 
-var sessionRegExp = regexp.MustCompile(sessionPrefix)
+// HandlerStats contains handler wide counters
+type HandlerStats struct {
+	// OpenSessions is the number of currently open sessions
+	OpenSessions int
+	// HandlerPanics is the number of handler functions that panicked
+	HandlerPanics uint64
+}
 
 // NewHandler creates new HTTP handler that conforms to the basic net/http.Handler interface.
-// It takes path prefix, options and sockjs handler function as parameters
+// It takes path prefix, options and sockjs handler function as parameters. Sessions of a nil
+// handler function are not closed on its return, they stay open like with Options.KeepSessionOnHandlerReturn.
 func NewHandler(prefix string, opts Options, handlerFunc func(Session)) *Handler {
-	if handlerFunc == nil {
-		handlerFunc = func(s Session) {}
-	}
 	h := &Handler{
 		prefix:      prefix,
 		options:     opts,
 		handlerFunc: handlerFunc,
-		sessions:    make(map[string]*session),
 	}
-
-	h.fillMappingsWithAllowedMethods()
-
-	if opts.Websocket {
-		h.mappings = append(h.mappings, newMapping("GET", sessionPrefix+"/websocket$", h.sockjsWebsocket))
+	var err error
+	if h.trustedProxies, err = parseTrustedProxies(opts.TrustedProxies); err != nil {
+		log.Printf("%v, not trusted", err)
 	}
-	if opts.RawWebsocket {
-		h.mappings = append(h.mappings, newMapping("GET", "^/websocket$", h.rawWebsocket))
+	if opts.TimerWheelTick > 0 {
+		h.timerWheel = newTimerWheels(opts.TimerWheelTick, defaultWheelSlots)
 	}
+
+	h.router = h.buildRouter()
 	return h
 }
 
 func (h *Handler) Prefix() string { return h.prefix }
 
+// ServeHTTP dispatches the request by its path. Like http.StripPrefix, it trims the handler prefix from the request
+// passed to transports, so Session.Request sees the path relative to the prefix.
 func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	// iterate over mappings
-	http.StripPrefix(h.prefix, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		var allowedMethods []string
-		for _, mapping := range h.mappings {
-			if match, method := mapping.matches(req); match == fullMatch {
-				for _, hf := range mapping.chain {
-					hf(rw, req)
-				}
-				return
-			} else if match == pathMatch {
-				allowedMethods = append(allowedMethods, method)
-			}
-		}
-		if len(allowedMethods) > 0 {
-			rw.Header().Set("allow", strings.Join(allowedMethods, ", "))
-			rw.Header().Set("Content-Type", "")
-			rw.WriteHeader(http.StatusMethodNotAllowed)
+	if h.prefix != "" {
+		stripped := stripPrefix(req, h.prefix)
+		if stripped == nil {
+			http.NotFound(rw, req)
 			return
 		}
+		req = stripped
+	}
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	e, sessionID := h.router.match(req.URL.Path)
+	if e == nil {
 		http.NotFound(rw, req)
-	})).ServeHTTP(rw, req)
+		return
+	}
+	e.serve(rw, req, sessionID)
+}
+
+// stripPrefix returns a copy of req with prefix trimmed from its URL path, nil if the path does not start with prefix
+func stripPrefix(req *http.Request, prefix string) *http.Request {
+	path := strings.TrimPrefix(req.URL.Path, prefix)
+	rawPath := strings.TrimPrefix(req.URL.RawPath, prefix)
+	if len(path) == len(req.URL.Path) || (req.URL.RawPath != "" && len(rawPath) == len(req.URL.RawPath)) {
+		return nil
+	}
+	stripped := new(http.Request)
+	*stripped = *req
+	stripped.URL = new(url.URL)
+	*stripped.URL = *req.URL
+	stripped.URL.Path = path
+	stripped.URL.RawPath = rawPath
+	return stripped
+}
+
+// startHandler runs handler function for the session in a new goroutine, or delivers open event
+// to the EventHandler (only once per session)
+func (h *Handler) startHandler(sess *session) {
+	if sess.events != nil {
+		sess.startHandlerOnce.Do(func() { sess.events.dispatch(sess, sessionEvent{kind: eventOpen}) })
+		return
+	}
+	sess.startHandlerOnce.Do(func() { go h.runHandler(sess) })
+}
+
+// runHandler runs handler function and closes the session once the function returns,
+// unless Options.KeepSessionOnHandlerReturn is set or there is no handler function.
+func (h *Handler) runHandler(sess *session) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recoverHandler(sess, r, debug.Stack())
+		}
+	}()
+	handlerFunc := h.handlerFunc
+	if sess.handlerFunc != nil {
+		handlerFunc = sess.handlerFunc
+	}
+	if handlerFunc == nil {
+		return
+	}
+	handlerFunc(sess)
+	if !h.options.KeepSessionOnHandlerReturn {
+		code, reason := h.options.HandlerReturnCloseCode, h.options.HandlerReturnCloseReason
+		if code == 0 {
+			code, reason = defaultHandlerReturnCloseCode, defaultHandlerReturnCloseReason
+		}
+		_ = sess.Close(code, reason)
+	}
+}
+
+// recoverHandler closes the session whose handler function panicked and reports the panic
+func (h *Handler) recoverHandler(sess *session, r interface{}, stack []byte) {
+	h.handlerPanics.Add(1)
+	_ = sess.Close(handlerPanicCloseCode, handlerPanicCloseReason)
+	if h.options.OnHandlerPanic != nil {
+		h.options.OnHandlerPanic(sess, r, stack)
+		return
+	}
+	log.Printf("sockjs: panic in handler function of session %q from %s: %v\n%s", sess.ID(), sess.RemoteAddr(), r, stack)
+}
+
+// Stats returns handler wide counters
+func (h *Handler) Stats() HandlerStats {
+	return HandlerStats{
+		OpenSessions:  int(h.openSessions.Load()),
+		HandlerPanics: h.handlerPanics.Load(),
+	}
 }
 
-func (h *Handler) parseSessionID(url *url.URL) (string, error) {
-	matches := sessionRegExp.FindStringSubmatch(url.Path)
-	if len(matches) == 3 {
-		return matches[2], nil
+// Shutdown closes all open sessions with code 1001 and reason "Server shutting down" and refuses new sessions.
+// Closed sessions report CloseCauseShutdown. Shutdown does not wait for the clients to receive the close frame.
+func (h *Handler) Shutdown() {
+	// sessions are created with their shard locked, so the ones created after their shard was visited see the flag
+	h.shuttingDown.Store(true)
+	for _, sess := range h.sessions.all() {
+		_ = sess.closeWithStatus(shutdownCloseCode, shutdownCloseReason, CloseCauseShutdown)
 	}
-	return "", errSessionParse
 }
 
-func (h *Handler) sessionByRequest(req *http.Request) (*session, error) {
-	h.sessionsMux.Lock()
-	defer h.sessionsMux.Unlock()
-	sessionID, err := h.parseSessionID(req.URL)
+// trackConn adds a websocket session to the session table, so that Shutdown closes it.
+// Remove it with sessions.removeConn once the connection ends.
+func (h *Handler) trackConn(sess *session) {
+	h.sessions.addConn(sess)
+	if h.shuttingDown.Load() { // Shutdown could have walked the table before the session was added
+		_ = sess.closeWithStatus(shutdownCloseCode, shutdownCloseReason, CloseCauseShutdown)
+	}
+}
+
+// createSession creates new session configured with handler options
+func (h *Handler) createSession(req *http.Request, sessionID string) *session {
+	sess := newSession(req, sessionID, h.options.DisconnectDelay, h.options.HeartbeatDelay)
+	sess.sendCoalesceWindow = h.options.SendCoalesceWindow
+	sess.asyncWriter = h.options.AsyncWriter
+	sess.conflictPolicy = h.options.ReceiverConflictPolicy
+	sess.heartbeatJitter = h.options.HeartbeatJitter
+	if h.timerWheel != nil {
+		sess.useScheduler(h.timerWheel)
+	}
+	if h.events != nil {
+		h.useEvents(sess)
+	}
+	if h.options.CSRFTokens {
+		sess.csrfToken = newCSRFToken()
+	}
+	sess.remoteAddr = h.trustedProxies.clientIP(req)
+	sess.idleCloseCode, sess.idleCloseReason = h.options.IdleTimeoutCloseCode, h.options.IdleTimeoutCloseReason
+	sess.lifetimeCloseCode, sess.lifetimeCloseReason = h.options.MaxSessionLifetimeCloseCode, h.options.MaxSessionLifetimeCloseReason
+	if h.options.IdleTimeout > 0 {
+		sess.startIdleTimer(h.options.IdleTimeout)
+	}
+	if h.options.MaxSessionLifetime > 0 {
+		_ = sess.ExtendLifetime(h.options.MaxSessionLifetime)
+	}
+	return sess
+}
+
+func (h *Handler) sessionByRequest(req *http.Request, sessionID string) (*session, error) {
+	if sess, ok := h.sessions.get(sessionID); ok {
+		sess.setCurrentRequest(req)
+		return sess, nil
+	}
+	// admitted outside of the table lock, so that AdmitSession does not block other sessions of the shard
+	release, err := h.admit(req)
 	if err != nil {
 		return nil, err
 	}
-	sess, exists := h.sessions[sessionID]
-	if !exists {
-		sess = newSession(req, sessionID, h.options.DisconnectDelay, h.options.HeartbeatDelay)
-		h.sessions[sessionID] = sess
-		go func() {
-			<-sess.closeCh
-			h.sessionsMux.Lock()
-			delete(h.sessions, sessionID)
-			h.sessionsMux.Unlock()
-		}()
+	sess, created, err := h.sessions.getOrCreate(sessionID, func() (*session, error) {
+		if h.shuttingDown.Load() { // checked with the shard locked, see Shutdown
+			return nil, errSessionLimit
+		}
+		return h.createSession(req, sessionID), nil
+	})
+	if !created {
+		release() // refused, or created by a concurrent request meanwhile
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		// registered outside of the table lock, the function runs right away if the session is closed already
+		sess.setOnClosed(func() {
+			h.sessions.remove(sessionID, sess)
+			release()
+		})
 	}
 	sess.setCurrentRequest(req)
 	return sess, nil
 }
 
-// fillMappingsWithAllowedMethods adds only allowed methods to handler.mappings, by if method is not disabled
-func (h *Handler) fillMappingsWithAllowedMethods() {
-
+// buildRouter builds the dispatch table of endpoints, disabled transports are left out
+func (h *Handler) buildRouter() router {
+	r := newRouter()
 	xhrCors := xhrCorsFactory(h.options)
 
 	// Default Methods
-	h.mappings = []*mapping{
-		newMapping("GET", "^[/]?$", welcomeHandler),
-		newMapping("OPTIONS", "^/info$", h.options.cookie, xhrCors, cacheFor, h.options.info),
-		newMapping("GET", "^/info$", h.options.cookie, xhrCors, noCache, h.options.info),
-		// IFrame
-		newMapping("GET", "^/iframe[0-9-.a-z_]*.html$", cacheFor, h.iframe),
-	}
+	r.path("").add("GET", nil, welcomeHandler)
+	r.path("/").add("GET", nil, welcomeHandler)
+	r.path("/info").add("OPTIONS", nil, h.options.cookie, xhrCors, cacheFor, h.options.info)
+	r.path("/info").add("GET", nil, h.options.cookie, xhrCors, noCache, h.options.info)
+	// IFrame
+	r.iframe.add("GET", nil, cacheFor, h.iframe)
 
-	// Adding XHR to mapping
 	if !h.options.DisableXHR {
-		h.mappings = append(h.mappings,
-			newMapping("POST", sessionPrefix+"/xhr$", h.options.cookie, xhrCors, noCache, h.xhrPoll),
-			newMapping("OPTIONS", sessionPrefix+"/xhr$", h.options.cookie, xhrCors, cacheFor, xhrOptions),
-		)
+		r.transport("xhr").add("POST", h.xhrPoll, h.options.cookie, xhrCors, noCache)
+		r.transport("xhr").add("OPTIONS", nil, h.options.cookie, xhrCors, cacheFor, xhrOptions)
 	}
-
-	// Adding XHRStreaming to mapping
 	if !h.options.DisableXHRStreaming {
-		h.mappings = append(h.mappings,
-			newMapping("POST", sessionPrefix+"/xhr_streaming$", h.options.cookie, xhrCors, noCache, h.xhrStreaming),
-			newMapping("OPTIONS", sessionPrefix+"/xhr_streaming$", h.options.cookie, xhrCors, cacheFor, xhrOptions),
-		)
+		r.transport("xhr_streaming").add("POST", h.xhrStreaming, h.options.cookie, xhrCors, noCache)
+		r.transport("xhr_streaming").add("OPTIONS", nil, h.options.cookie, xhrCors, cacheFor, xhrOptions)
 	}
-
-	// Adding EventSource to mapping
 	if !h.options.DisableEventSource {
-		h.mappings = append(h.mappings,
-			newMapping("GET", sessionPrefix+"/eventsource$", h.options.cookie, xhrCors, noCache, h.eventSource),
-		)
+		r.transport("eventsource").add("GET", h.eventSource, h.options.cookie, xhrCors, noCache)
 	}
-
-	// Adding HtmlFile to mapping
 	if !h.options.DisableHtmlFile {
-		h.mappings = append(h.mappings,
-			newMapping("GET", sessionPrefix+"/htmlfile$", h.options.cookie, xhrCors, noCache, h.htmlFile),
-		)
+		r.transport("htmlfile").add("GET", h.htmlFile, h.options.cookie, xhrCors, noCache)
 	}
-
-	// Adding JSONP to mapping
 	if !h.options.DisableJSONP {
-		h.mappings = append(h.mappings,
-			newMapping("GET", sessionPrefix+"/jsonp$", h.options.cookie, xhrCors, noCache, h.jsonp),
-			newMapping("OPTIONS", sessionPrefix+"/jsonp$", h.options.cookie, xhrCors, cacheFor, xhrOptions),
-			newMapping("POST", sessionPrefix+"/jsonp_send$", h.options.cookie, xhrCors, noCache, h.jsonpSend),
-		)
+		r.transport("jsonp").add("GET", h.jsonp, h.options.cookie, xhrCors, noCache)
+		r.transport("jsonp").add("OPTIONS", nil, h.options.cookie, xhrCors, cacheFor, xhrOptions)
+		r.transport("jsonp_send").add("POST", h.jsonpSend, h.options.cookie, xhrCors, noCache)
 	}
-
 	// when adding XHRPoll or/and XHRStreaming xhr_send must be added too (only once)
 	if !h.options.DisableXHR || !h.options.DisableXHRStreaming {
-		h.mappings = append(h.mappings,
-			newMapping("POST", sessionPrefix+"/xhr_send$", h.options.cookie, xhrCors, noCache, h.xhrSend),
-			newMapping("OPTIONS", sessionPrefix+"/xhr_send$", h.options.cookie, xhrCors, cacheFor, xhrOptions),
-		)
+		r.transport("xhr_send").add("POST", h.xhrSend, h.options.cookie, xhrCors, noCache)
+		r.transport("xhr_send").add("OPTIONS", nil, h.options.cookie, xhrCors, cacheFor, xhrOptions)
+	}
+	if h.options.Websocket {
+		r.transport("websocket").add("GET", h.sockjsWebsocket)
+	}
+	if h.options.RawWebsocket {
+		r.path("/websocket").add("GET", nil, h.rawWebsocket)
 	}
+	return r
 }
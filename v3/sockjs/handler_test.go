@@ -2,13 +2,14 @@ package sockjs
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -24,9 +25,6 @@ func TestHandler_Create(t *testing.T) {
 	if handler.Prefix() != "/echo" {
 		t.Errorf("Prefix not properly set, got '%s' expected '%s'", handler.Prefix(), "/echo")
 	}
-	if handler.sessions == nil {
-		t.Errorf("Handler session map not made")
-	}
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -86,27 +84,19 @@ func TestHandler_RootPrefixInfoHandler(t *testing.T) {
 	}
 }
 
-func TestHandler_ParseSessionId(t *testing.T) {
-	h := Handler{prefix: "/prefix"}
-	url, _ := url.Parse("http://server:80/server/session/whatever")
-	if session, err := h.parseSessionID(url); session != "session" || err != nil {
-		t.Errorf("Wrong session parsed, got '%s' expected '%s' with error = '%v'", session, "session", err)
-	}
-}
-
 func TestHandler_SessionByRequest(t *testing.T) {
 	h := NewHandler("", testOptions, nil)
 	h.options.DisconnectDelay = 10 * time.Millisecond
 	var handlerFuncCalled = make(chan Session)
 	h.handlerFunc = func(s Session) { handlerFuncCalled <- s }
 	req, _ := http.NewRequest("POST", "/server/sessionid/whatever/follows", nil)
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, "sessionid")
 	if sess == nil || err != nil {
 		t.Errorf("session should be returned")
 		// test handlerFunc was called
 		select {
 		case s := <-handlerFuncCalled: // ok
-			if s.session != sess {
+			if s != Session(sess) {
 				t.Errorf("Handler was not passed correct session")
 			}
 		case <-time.After(100 * time.Millisecond):
@@ -115,21 +105,14 @@ func TestHandler_SessionByRequest(t *testing.T) {
 	}
 	// test session is reused for multiple requests with same sessionID
 	req2, _ := http.NewRequest("POST", "/server/sessionid/whatever", nil)
-	if sess2, err := h.sessionByRequest(req2); sess2 != sess || err != nil {
+	if sess2, err := h.sessionByRequest(req2, "sessionid"); sess2 != sess || err != nil {
 		t.Errorf("Expected error, got session: '%v'", sess)
 	}
 	// test session expires after timeout
 	time.Sleep(15 * time.Millisecond)
-	h.sessionsMux.Lock()
-	if _, exists := h.sessions["sessionid"]; exists {
+	if _, exists := h.sessions.get("sessionid"); exists {
 		t.Errorf("session should not exist in handler after timeout")
 	}
-	h.sessionsMux.Unlock()
-	// test proper behaviour in case URL is not correct
-	req, _ = http.NewRequest("POST", "", nil)
-	if _, err := h.sessionByRequest(req); err == nil {
-		t.Errorf("Expected parser sessionID from URL error, got 'nil'")
-	}
 }
 
 func TestHandler_StrictPathMatching(t *testing.T) {
@@ -239,3 +222,186 @@ func TestHandler_DisabledMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_CloseOnHandlerReturn(t *testing.T) {
+	cases := []struct {
+		name          string
+		code          uint32
+		reason        string
+		expectedFrame string
+	}{
+		{"default", 0, "", `c[3000,"Handler finished"]`},
+		{"custom", 4000, "bye", `c[4000,"bye"]`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.options.HandlerReturnCloseCode = c.code
+			h.options.HandlerReturnCloseReason = c.reason
+			server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+			defer server.Close()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			for _, expected := range []string{"o", c.expectedFrame} {
+				_, msg, err := conn.ReadMessage()
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(msg))
+			}
+		})
+	}
+}
+
+func TestHandler_KeepSessionOnHandlerReturn(t *testing.T) {
+	h := newTestHandler()
+	h.options.KeepSessionOnHandlerReturn = true
+	returned := false
+	h.handlerFunc = func(Session) { returned = true }
+	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+	sess, err := h.sessionByRequest(req, "session")
+	require.NoError(t, err)
+	defer sess.close()
+	h.runHandler(sess) // runs the handler function synchronously, the session is closed (or not) once it returns
+	require.True(t, returned)
+	assert.Equal(t, SessionOpening, sess.GetSessionState())
+}
+
+func TestHandler_NilHandlerKeepsSession(t *testing.T) {
+	h := NewHandler("", DefaultOptions, nil)
+	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+	sess, err := h.sessionByRequest(req, "session")
+	require.NoError(t, err)
+	defer sess.close()
+	h.runHandler(sess)
+	assert.Equal(t, SessionOpening, sess.GetSessionState(), "session without handler function should stay open")
+}
+
+func TestHandler_RecoverHandlerPanic(t *testing.T) {
+	h := newTestHandler()
+	h.handlerFunc = func(s Session) {
+		if msg, _ := s.Recv(); msg == "bad message" {
+			panic("bad message received")
+		}
+	}
+	type panicReport struct {
+		recovered interface{}
+		stack     string
+	}
+	reports := make(chan panicReport, 1)
+	h.options.OnHandlerPanic = func(s Session, recovered interface{}, stack []byte) {
+		reports <- panicReport{recovered, string(stack)}
+	}
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON([]string{"bad message"}))
+	for _, expected := range []string{"o", `c[1011,"Internal error"]`} {
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(msg))
+	}
+	select {
+	case report := <-reports:
+		assert.Equal(t, "bad message received", report.recovered)
+		assert.Contains(t, report.stack, "TestHandler_RecoverHandlerPanic")
+	case <-time.After(time.Second):
+		t.Fatal("panic hook should be called")
+	}
+	assert.Equal(t, uint64(1), h.Stats().HandlerPanics)
+
+	// other sessions are not affected
+	conn2, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, msg, err := conn2.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "o", string(msg))
+}
+
+func TestHandler_RecoverHandlerPanicReported(t *testing.T) {
+	h := newTestHandler()
+	var reported Session
+	var recovered interface{}
+	var stack string
+	h.options.OnHandlerPanic = func(s Session, r interface{}, st []byte) {
+		reported, recovered, stack = s, r, string(st)
+	}
+	sess := newTestSession()
+	h.recoverHandler(sess, "boom", []byte("stack trace"))
+	assert.Equal(t, Session(sess), reported)
+	assert.Equal(t, "boom", recovered)
+	assert.Equal(t, "stack trace", stack)
+	assert.Equal(t, uint64(1), h.Stats().HandlerPanics)
+	var closeErr *CloseError
+	require.True(t, errors.As(sess.Err(), &closeErr))
+	assert.Equal(t, uint32(handlerPanicCloseCode), closeErr.Code)
+}
+
+func TestHandler_Shutdown(t *testing.T) {
+	h := newTestHandler()
+	// sessions must be open when Shutdown closes them
+	h.options.KeepSessionOnHandlerReturn = true
+	sessions := make(chan Session, 1)
+	h.handlerFunc = func(s Session) { sessions <- s }
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	sess := (<-sessions).(*session)
+	h.Shutdown()
+	for _, expected := range []string{"o", `c[1001,"Server shutting down"]`} {
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(msg))
+	}
+	var closeErr *CloseError
+	require.True(t, errors.As(sess.Err(), &closeErr))
+	assert.Equal(t, CloseCauseShutdown, closeErr.Cause)
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestHandler_ShutdownWithoutReceiver(t *testing.T) {
+	h := newTestHandler()
+	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+	sess, err := h.sessionByRequest(req, "session")
+	require.NoError(t, err)
+	h.Shutdown()
+	var closeErr *CloseError
+	require.True(t, errors.As(sess.Err(), &closeErr))
+	assert.Equal(t, CloseCauseShutdown, closeErr.Cause)
+	assert.Equal(t, uint32(shutdownCloseCode), closeErr.Code)
+	// kept until the client polls for the close frame or DisconnectDelay passes
+	assert.Equal(t, SessionClosing, sess.GetSessionState())
+
+	req, _ = http.NewRequest("POST", "/server/another/xhr", nil)
+	_, err = h.sessionByRequest(req, "another")
+	assert.Equal(t, errSessionLimit, err)
+	h.Shutdown() // idempotent
+}
+
+func TestHandler_IdleTimeout(t *testing.T) {
+	h := newTestHandler()
+	// the session must stay open until it idles out
+	h.options.KeepSessionOnHandlerReturn = true
+	h.options.IdleTimeout = 20 * time.Millisecond
+	h.options.IdleTimeoutCloseCode, h.options.IdleTimeoutCloseReason = 4408, "Idle"
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, expected := range []string{"o", `c[4408,"Idle"]`} {
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(msg))
+	}
+}
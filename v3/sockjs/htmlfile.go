@@ -29,8 +29,9 @@ func init() {
 	iframeTemplate += "\r\n\r\n"
 }
 
-func (h *Handler) htmlFile(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) htmlFile(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	rw.Header().Set("content-type", "text/html; charset=UTF-8")
+	h.securityHeaders(rw)
 
 	if err := req.ParseForm(); err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
@@ -47,9 +48,9 @@ func (h *Handler) htmlFile(rw http.ResponseWriter, req *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	fmt.Fprintf(rw, iframeTemplate, callback)
 	rw.(http.Flusher).Flush()
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, sessionID)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		sessionError(rw, new(htmlfileFrameWriter), err)
 		return
 	}
 	recv := newHTTPReceiver(rw, req, h.options.ResponseLimit, new(htmlfileFrameWriter), ReceiverTypeHtmlFile)
@@ -61,15 +62,17 @@ func (h *Handler) htmlFile(rw http.ResponseWriter, req *http.Request) {
 		recv.close()
 		return
 	}
-	sess.startHandlerOnce.Do(func() { go h.handlerFunc(Session{sess}) })
-	select {
-	case <-recv.doneNotify():
-	case <-recv.interruptedNotify():
-	}
+	h.startHandler(sess)
+	sess.serveReceiver(recv)
 }
 
 type htmlfileFrameWriter struct{}
 
-func (*htmlfileFrameWriter) write(w io.Writer, frame string) (int, error) {
-	return fmt.Fprintf(w, "<script>\np(%s);\n</script>\r\n", quote(frame))
+func (*htmlfileFrameWriter) write(w io.Writer, frame []byte) (int, error) {
+	fb := getFrameBuffer()
+	defer putFrameBuffer(fb)
+	fb.b = append(fb.b, "<script>\np("...)
+	fb.b = appendQuote(fb.b, frameString(frame))
+	fb.b = append(fb.b, ");\n</script>\r\n"...)
+	return w.Write(fb.b)
 }
@@ -11,7 +11,7 @@ func TestHandler_htmlFileNoCallback(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/server/session/htmlfile", nil)
-	h.htmlFile(rw, req)
+	withSessionID(h.htmlFile)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusBadRequest)
 	}
@@ -25,7 +25,7 @@ func TestHandler_htmlFile(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/server/session/htmlfile?c=testCallback", nil)
-	h.htmlFile(rw, req)
+	withSessionID(h.htmlFile)(rw, req)
 	if rw.Code != http.StatusOK {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusOK)
 	}
@@ -36,7 +36,7 @@ func TestHandler_htmlFile(t *testing.T) {
 	if rw.Body.String() != expectedIFrame {
 		t.Errorf("Unexpected response body, got '%s', expected '%s'", rw.Body, expectedIFrame)
 	}
-	sess, _ := h.sessionByRequest(req)
+	sess, _ := h.sessionByRequest(req, "session")
 	if rt := sess.ReceiverType(); rt != ReceiverTypeHtmlFile {
 		t.Errorf("Unexpected recevier type, got '%v', extected '%v'", rt, ReceiverTypeHtmlFile)
 	}
@@ -47,7 +47,7 @@ func TestHandler_cannotIntoXSS(t *testing.T) {
 	rw := httptest.NewRecorder()
 	// test simple injection
 	req, _ := http.NewRequest("GET", "/server/session/htmlfile?c=fake%3Balert(1337)", nil)
-	h.htmlFile(rw, req)
+	withSessionID(h.htmlFile)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusBadRequest)
 	}
@@ -56,7 +56,7 @@ func TestHandler_cannotIntoXSS(t *testing.T) {
 	rw = httptest.NewRecorder()
 	// test simple injection
 	req, _ = http.NewRequest("GET", "/server/session/htmlfile?c=fake%2Dalert", nil)
-	h.htmlFile(rw, req)
+	withSessionID(h.htmlFile)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusBadRequest)
 	}
@@ -1,15 +1,14 @@
 package sockjs
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"net/http"
-	"strings"
 	"sync"
 )
 
 type frameWriter interface {
-	write(writer io.Writer, frame string) (int, error)
+	write(writer io.Writer, frame []byte) (int, error)
 }
 
 type httpReceiverState int
@@ -29,6 +28,7 @@ type httpReceiver struct {
 	currentResponseSize uint32
 	doneCh              chan struct{}
 	interruptCh         chan struct{}
+	stopInterrupt       func() bool // stops watching request context
 	recType             ReceiverType
 }
 
@@ -41,53 +41,51 @@ func newHTTPReceiver(rw http.ResponseWriter, req *http.Request, maxResponse uint
 		interruptCh:     make(chan struct{}),
 		recType:         receiverType,
 	}
-	ctx := req.Context()
-
-	go func() {
-		select {
-		case <-ctx.Done():
-			recv.Lock()
-			defer recv.Unlock()
-			if recv.state < stateHTTPReceiverClosed {
-				recv.state = stateHTTPReceiverClosed
-				close(recv.interruptCh)
-			}
-		case <-recv.doneCh:
-			// ok, no action needed here, receiver closed in correct way
-			// just finish the routine
+	// no goroutine is started unless the request context gets done
+	recv.stopInterrupt = context.AfterFunc(req.Context(), func() {
+		recv.Lock()
+		defer recv.Unlock()
+		if recv.state < stateHTTPReceiverClosed {
+			recv.state = stateHTTPReceiverClosed
+			close(recv.interruptCh)
 		}
-	}()
+	})
 	return recv
 }
 
 func (recv *httpReceiver) sendBulk(messages ...string) error {
 	if len(messages) > 0 {
-		return recv.sendFrame(fmt.Sprintf("a[%s]",
-			strings.Join(
-				transform(messages, quote),
-				",",
-			),
-		))
+		fb := getFrameBuffer()
+		fb.b = appendBulkFrame(fb.b, messages, true)
+		err := recv.writeFrame(fb.b)
+		putFrameBuffer(fb)
+		return err
 	}
 	return nil
 }
 
 func (recv *httpReceiver) sendFrame(value string) error {
+	return recv.writeFrame([]byte(value))
+}
+
+func (recv *httpReceiver) writeFrame(frame []byte) error {
 	recv.Lock()
 	defer recv.Unlock()
 
-	if recv.state == stateHTTPReceiverActive {
-		n, err := recv.frameWriter.write(recv.rw, value)
-		if err != nil {
-			return err
-		}
-		recv.currentResponseSize += uint32(n)
-		if recv.currentResponseSize >= recv.maxResponseSize {
-			recv.state = stateHTTPReceiverClosed
-			close(recv.doneCh)
-		} else {
-			recv.rw.(http.Flusher).Flush()
-		}
+	if recv.state != stateHTTPReceiverActive {
+		return errReceiverClosed
+	}
+	n, err := recv.frameWriter.write(recv.rw, frame)
+	if err != nil {
+		return err
+	}
+	recv.currentResponseSize += uint32(n)
+	if recv.currentResponseSize >= recv.maxResponseSize {
+		recv.state = stateHTTPReceiverClosed
+		close(recv.doneCh)
+		recv.stopInterrupt()
+	} else {
+		recv.rw.(http.Flusher).Flush()
 	}
 	return nil
 }
@@ -100,6 +98,7 @@ func (recv *httpReceiver) close() {
 	if recv.state < stateHTTPReceiverClosed {
 		recv.state = stateHTTPReceiverClosed
 		close(recv.doneCh)
+		recv.stopInterrupt()
 	}
 }
 func (recv *httpReceiver) canSend() bool {
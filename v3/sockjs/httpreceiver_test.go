@@ -13,8 +13,8 @@ type testFrameWriter struct {
 	frames []string
 }
 
-func (t *testFrameWriter) write(w io.Writer, frame string) (int, error) {
-	t.frames = append(t.frames, frame)
+func (t *testFrameWriter) write(w io.Writer, frame []byte) (int, error) {
+	t.frames = append(t.frames, string(frame))
 	return len(frame), nil
 }
 
@@ -97,6 +97,9 @@ func TestHttpReceiver_Close(t *testing.T) {
 	if recv.state != stateHTTPReceiverClosed {
 		t.Errorf("Unexpected state, got '%d', expected '%d'", recv.state, stateHTTPReceiverClosed)
 	}
+	if err := recv.sendFrame("h"); err != errReceiverClosed {
+		t.Errorf("Unexpected error of write to closed receiver, got '%v', expected '%v'", err, errReceiverClosed)
+	}
 }
 
 func TestHttpReceiver_ConnectionInterrupt(t *testing.T) {
@@ -23,6 +23,7 @@ func (h *Handler) iframe(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	rw.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	h.securityHeaders(rw)
 	rw.Header().Add("ETag", etag)
 	if err := tmpl.Execute(rw, h.options.SockJSURL); err!=nil {
 			http.Error(rw, "could not render iframe content: "+err.Error(), http.StatusInternalServerError)
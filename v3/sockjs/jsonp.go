@@ -2,13 +2,12 @@ package sockjs
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
 )
 
-func (h *Handler) jsonp(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) jsonp(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	rw.Header().Set("content-type", "application/javascript; charset=UTF-8")
 
 	if err := req.ParseForm(); err != nil {
@@ -26,9 +25,9 @@ func (h *Handler) jsonp(rw http.ResponseWriter, req *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	rw.(http.Flusher).Flush()
 
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, sessionID)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		sessionError(rw, &jsonpFrameWriter{callback}, err)
 		return
 	}
 	recv := newHTTPReceiver(rw, req, 1, &jsonpFrameWriter{callback}, ReceiverTypeJSONP)
@@ -40,14 +39,15 @@ func (h *Handler) jsonp(rw http.ResponseWriter, req *http.Request) {
 		recv.close()
 		return
 	}
-	sess.startHandlerOnce.Do(func() { go h.handlerFunc(Session{sess}) })
-	select {
-	case <-recv.doneNotify():
-	case <-recv.interruptedNotify():
-	}
+	h.startHandler(sess)
+	sess.serveReceiver(recv)
 }
 
-func (h *Handler) jsonpSend(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) jsonpSend(rw http.ResponseWriter, req *http.Request, sessionID string) {
+	if !h.sendOriginAllowed(req) {
+		http.Error(rw, "Origin not allowed.", http.StatusForbidden)
+		return
+	}
 	if err := req.ParseForm(); err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -73,12 +73,11 @@ func (h *Handler) jsonpSend(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "Broken JSON encoding.", http.StatusBadRequest)
 		return
 	}
-	sessionID, _ := h.parseSessionID(req.URL)
-	h.sessionsMux.Lock()
-	sess, ok := h.sessions[sessionID]
-	h.sessionsMux.Unlock()
+	sess, ok := h.sessions.get(sessionID)
 	if !ok {
 		http.NotFound(rw, req)
+	} else if !checkCSRFToken(sess, req) {
+		http.Error(rw, "Invalid CSRF token.", http.StatusForbidden)
 	} else {
 		if err := sess.accept(messages...); err != nil {
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -93,6 +92,12 @@ type jsonpFrameWriter struct {
 	callback string
 }
 
-func (j *jsonpFrameWriter) write(w io.Writer, frame string) (int, error) {
-	return fmt.Fprintf(w, "%s(%s);\r\n", j.callback, quote(frame))
+func (j *jsonpFrameWriter) write(w io.Writer, frame []byte) (int, error) {
+	fb := getFrameBuffer()
+	defer putFrameBuffer(fb)
+	fb.b = append(fb.b, j.callback...)
+	fb.b = append(fb.b, '(')
+	fb.b = appendQuote(fb.b, frameString(frame))
+	fb.b = append(fb.b, ");\r\n"...)
+	return w.Write(fb.b)
 }
@@ -12,7 +12,7 @@ func TestHandler_jsonpNoCallback(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/server/session/jsonp", nil)
-	h.jsonp(rw, req)
+	withSessionID(h.jsonp)(rw, req)
 	if rw.Code != http.StatusInternalServerError {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusInternalServerError)
 	}
@@ -26,7 +26,7 @@ func TestHandler_jsonp(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/server/session/jsonp?c=testCallback", nil)
-	h.jsonp(rw, req)
+	withSessionID(h.jsonp)(rw, req)
 	expectedContentType := "application/javascript; charset=UTF-8"
 	if rw.Header().Get("content-type") != expectedContentType {
 		t.Errorf("Unexpected content type, got '%s', expected '%s'", rw.Header().Get("content-type"), expectedContentType)
@@ -35,7 +35,7 @@ func TestHandler_jsonp(t *testing.T) {
 	if rw.Body.String() != expectedBody {
 		t.Errorf("Unexpected body, got '%s', expected '%s'", rw.Body, expectedBody)
 	}
-	sess, _ := h.sessionByRequest(req)
+	sess, _ := h.sessionByRequest(req, "session")
 	if rt := sess.ReceiverType(); rt != ReceiverTypeJSONP {
 		t.Errorf("Unexpected recevier type, got '%v', extected '%v'", rt, ReceiverTypeJSONP)
 	}
@@ -45,7 +45,7 @@ func TestHandler_jsonpSendNoPayload(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/jsonp_send", nil)
-	h.jsonpSend(rw, req)
+	withSessionID(h.jsonpSend)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusInternalServerError)
 	}
@@ -55,7 +55,7 @@ func TestHandler_jsonpSendWrongPayload(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/jsonp_send", strings.NewReader("wrong payload"))
-	h.jsonpSend(rw, req)
+	withSessionID(h.jsonpSend)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusInternalServerError)
 	}
@@ -65,7 +65,7 @@ func TestHandler_jsonpSendNoSession(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/jsonp_send", strings.NewReader("[\"message\"]"))
-	h.jsonpSend(rw, req)
+	withSessionID(h.jsonpSend)(rw, req)
 	if rw.Code != http.StatusNotFound {
 		t.Errorf("Unexpected response code, got '%d', expected '%d'", rw.Code, http.StatusNotFound)
 	}
@@ -78,11 +78,11 @@ func TestHandler_jsonpSend(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/server/session/jsonp_send", strings.NewReader("[\"message\"]"))
 
 	sess := newSession(req, "session", time.Second, time.Second)
-	h.sessions["session"] = sess
+	storeTestSession(h, "session", sess)
 
 	var done = make(chan struct{})
 	go func() {
-		h.jsonpSend(rw, req)
+		withSessionID(h.jsonpSend)(rw, req)
 		close(done)
 	}()
 	msg, _ := sess.Recv()
@@ -105,7 +105,7 @@ func TestHandler_jsonpCannotIntoXSS(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/server/session/jsonp?c=%3Chtml%3E%3Chead%3E%3Cscript%3Ealert(5520)%3C%2Fscript%3E", nil)
-	h.jsonp(rw, req)
+	withSessionID(h.jsonp)(rw, req)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("JsonP forwarded an exploitable response.")
 	}
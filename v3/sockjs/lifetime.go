@@ -0,0 +1,73 @@
+package sockjs
+
+import "time"
+
+// startIdleTimer closes the session once no message is received for the timeout. Must be called before
+// the session is used.
+func (s *session) startIdleTimer(timeout time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.idleTimeout = timeout
+	s.lastRecv.Store(time.Now().UnixNano())
+	s.idleTimer = s.scheduler.AfterFunc(timeout, s.idleCheck)
+}
+
+// idleCheck is called by idle timer. Instead of resetting the timer on every received message
+// the timer is rescheduled here according to the time of the last message.
+func (s *session) idleCheck() {
+	idle := time.Since(time.Unix(0, s.lastRecv.Load()))
+	if remaining := s.idleTimeout - idle; remaining > 0 {
+		s.mux.Lock()
+		if s.state < SessionClosing {
+			s.idleTimer = s.scheduler.AfterFunc(remaining, s.idleCheck)
+		}
+		s.mux.Unlock()
+		return
+	}
+	code, reason := s.idleCloseCode, s.idleCloseReason
+	if code == 0 {
+		code, reason = defaultIdleCloseCode, defaultIdleCloseReason
+	}
+	_ = s.closeWithStatus(code, reason, CloseCauseIdleTimeout)
+}
+
+// ExtendLifetime sets the session to be closed d from now, replacing the lifetime set by Options.MaxSessionLifetime
+// or a previous call. It is meant to be called after the client refreshed its credentials, and works even if
+// Options.MaxSessionLifetime is not set.
+func (s *session) ExtendLifetime(d time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state > SessionActive {
+		return s.errLocked()
+	}
+	s.lifetimeDeadline = time.Now().Add(d)
+	if s.lifetimeTimer != nil {
+		s.lifetimeTimer.Stop()
+	}
+	s.lifetimeTimer = s.scheduler.AfterFunc(d, s.lifetimeExceeded)
+	return nil
+}
+
+func (s *session) lifetimeExceeded() {
+	s.mux.RLock()
+	extended := time.Now().Before(s.lifetimeDeadline) // timer fired while ExtendLifetime replaced it
+	code, reason := s.lifetimeCloseCode, s.lifetimeCloseReason
+	s.mux.RUnlock()
+	if extended {
+		return
+	}
+	if code == 0 {
+		code, reason = defaultLifetimeCloseCode, defaultLifetimeCloseReason
+	}
+	_ = s.closeWithStatus(code, reason, CloseCauseLifetimeExceeded)
+}
+
+// stopLimitTimers is called with session lock held once the session is closing
+func (s *session) stopLimitTimers() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.lifetimeTimer != nil {
+		s.lifetimeTimer.Stop()
+	}
+}
@@ -0,0 +1,72 @@
+package sockjs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitClosed(t *testing.T, sess *session, within time.Duration) {
+	t.Helper()
+	select {
+	case <-sess.Context().Done():
+	case <-time.After(within):
+		t.Fatalf("Session should be closed within %v", within)
+	}
+}
+
+func TestSession_IdleTimeout(t *testing.T) {
+	sess := newTestSession()
+	sess.startIdleTimer(50 * time.Millisecond)
+	go func() {
+		for {
+			if _, err := sess.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		noError(t, sess.accept("message"))
+	}
+	if sess.GetSessionState() > SessionActive {
+		t.Fatalf("Received messages should keep the session open, got '%v'", sess.GetSessionState())
+	}
+	waitClosed(t, sess, time.Second)
+	var closeErr *CloseError
+	if !errors.As(sess.Err(), &closeErr) || closeErr.Cause != CloseCauseIdleTimeout {
+		t.Fatalf("Unexpected session error, got '%v'", sess.Err())
+	}
+	if closeErr.Code != defaultIdleCloseCode || sess.closeFrame != `c[3001,"Session idle"]` {
+		t.Errorf("Unexpected close frame '%s'", sess.closeFrame)
+	}
+}
+
+func TestSession_MaxLifetime(t *testing.T) {
+	sess := newTestSession()
+	sess.lifetimeCloseCode, sess.lifetimeCloseReason = 4401, "Reauthenticate"
+	noError(t, sess.ExtendLifetime(20*time.Millisecond))
+	waitClosed(t, sess, time.Second)
+	var closeErr *CloseError
+	if !errors.As(sess.Err(), &closeErr) || closeErr.Cause != CloseCauseLifetimeExceeded {
+		t.Fatalf("Unexpected session error, got '%v'", sess.Err())
+	}
+	if sess.closeFrame != `c[4401,"Reauthenticate"]` {
+		t.Errorf("Unexpected close frame '%s'", sess.closeFrame)
+	}
+	if err := sess.ExtendLifetime(time.Second); !errors.Is(err, ErrSessionNotOpen) {
+		t.Errorf("Closed session lifetime should not be extended, got '%v'", err)
+	}
+}
+
+func TestSession_ExtendLifetime(t *testing.T) {
+	sess := newTestSession()
+	noError(t, sess.ExtendLifetime(30*time.Millisecond))
+	time.Sleep(15 * time.Millisecond)
+	noError(t, sess.ExtendLifetime(time.Hour))
+	time.Sleep(50 * time.Millisecond)
+	if sess.GetSessionState() > SessionActive {
+		t.Errorf("Extended session should stay open, got '%v'", sess.GetSessionState())
+	}
+	sess.close()
+}
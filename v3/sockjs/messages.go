@@ -0,0 +1,55 @@
+package sockjs
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// Messages returns an iterator over messages the session receives from the client (see RecvCtx).
+// Iteration stops once the session gets closed, Err then tells why. If ctx is done first the last
+// pair yielded carries the ctx error. Sessions served by an EventHandler do not use it.
+//
+//	for msg, err := range sess.Messages(ctx) {
+//		if err != nil {
+//			return err // ctx done
+//		}
+//		...
+//	}
+func (s *session) Messages(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for {
+			msg, err := s.RecvCtx(ctx)
+			if errors.Is(err, ErrSessionNotOpen) {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// MessageChan returns a channel of messages the session receives from the client. The channel gets closed
+// once the session gets closed (Err then tells why), ctx is done (ctx.Err then tells why) or the returned
+// cancel function is called. Messages are received by a goroutine that exits with the channel, call cancel
+// to release it when the channel is not read till closed.
+func (s *session) MessageChan(ctx context.Context) (<-chan string, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for {
+			msg, err := s.RecvCtx(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, cancel
+}
@@ -0,0 +1,103 @@
+package sockjs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_Messages(t *testing.T) {
+	sess := newTestSession()
+	go func() {
+		_ = sess.accept("a", "b")
+		_ = sess.Close(3000, "bye")
+	}()
+	var received []string
+	for msg, err := range sess.Messages(context.Background()) {
+		if err != nil {
+			t.Fatalf("Unexpected error '%v'", err)
+		}
+		received = append(received, msg)
+	}
+	assert.Equal(t, []string{"a", "b"}, received)
+	var closeErr *CloseError
+	if !errors.As(sess.Err(), &closeErr) || closeErr.Code != 3000 {
+		t.Errorf("Unexpected close reason '%v'", sess.Err())
+	}
+}
+
+func TestSession_MessagesContextDone(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var errs []error
+	for _, err := range sess.Messages(ctx) {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, []error{context.DeadlineExceeded}, errs)
+}
+
+func TestSession_MessagesBreak(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	go func() { _ = sess.accept("a", "b") }()
+	for msg := range sess.Messages(context.Background()) {
+		assert.Equal(t, "a", msg)
+		break
+	}
+	msg, err := sess.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", msg, "messages after break should stay in session")
+}
+
+func TestSession_MessageChan(t *testing.T) {
+	sess := newTestSession()
+	go func() {
+		_ = sess.accept("a", "b")
+		sess.close()
+	}()
+	var received []string
+	ch, cancel := sess.MessageChan(context.Background())
+	defer cancel()
+	for msg := range ch {
+		received = append(received, msg)
+	}
+	assert.Equal(t, []string{"a", "b"}, received)
+	assert.Error(t, sess.Err())
+}
+
+func TestSession_MessageChanContextDone(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	go func() { _ = sess.accept("a") }()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, stop := sess.MessageChan(ctx)
+	defer stop()
+	assert.Equal(t, "a", <-ch)
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok, "channel should be closed")
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after context was cancelled")
+	}
+	assert.NoError(t, sess.Err())
+}
+
+func TestSession_MessageChanCancel(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	ch, cancel := sess.MessageChan(context.Background())
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok, "channel should be closed")
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel was called")
+	}
+	assert.NoError(t, sess.Err())
+}
@@ -40,16 +40,55 @@ type Options struct {
 	Websocket bool
 	// This option can be used to enable raw websockets support by the server. By default raw websockets are disabled.
 	RawWebsocket bool
+	// RawWebsocketProtocols maps websocket subprotocols to handler functions for raw websocket endpoint.
+	// The first protocol in client's Sec-WebSocket-Protocol header found in the map is negotiated and its handler
+	// function serves the session instead of the one passed to NewHandler. Clients requesting no protocol from the
+	// map are rejected with 400 Bad Request. The negotiated protocol is available as Session.Protocol.
+	// If empty (default), any client is accepted.
+	RawWebsocketProtocols map[string]func(Session)
 	// Provide a custom Upgrader for Websocket connections to enable features like compression.
 	// See https://godoc.org/github.com/gorilla/websocket#Upgrader for more details.
 	WebsocketUpgrader *websocket.Upgrader
 	// WebsocketWriteTimeout is a custom write timeout for Websocket underlying network connection.
 	// A zero value means writes will not time out.
 	WebsocketWriteTimeout time.Duration
+	// DisableWebsocketHTMLEscape stops escaping of <, > and & in messages sent over SockJS websocket transport.
+	// The escaping protects HTTP transports embedding messages in HTML or script, websocket frames are never
+	// interpreted that way, so the escaping only makes them larger. By default the characters are escaped.
+	DisableWebsocketHTMLEscape bool
+	// SendCoalesceWindow delays writing of messages sent to an attached receiver by up to the given duration,
+	// so that all messages sent within the window are written to the wire in a single frame.
+	// This trades a small latency for considerably less framing and syscall overhead on high-fan-out feeds.
+	// A zero value (default) writes every message immediately.
+	SendCoalesceWindow time.Duration
+	// ReceiverConflictPolicy decides what happens when a client opens a receiving connection (i.e. xhr_streaming)
+	// while another one is still attached to the session. By default the new connection is rejected with
+	// c[2010,"Another connection still open"], ReceiverConflictTakeover lets the new connection replace the old one.
+	ReceiverConflictPolicy ReceiverConflictPolicy
+	// WebsocketPingInterval enables detection of dead peers on websocket connections. Every interval a protocol level
+	// ping is sent and the client has to answer with a pong within WebsocketPongTimeout, otherwise the session is closed.
+	// The measured round-trip time is available as Session.RTT. A zero value (default) disables pings.
+	WebsocketPingInterval time.Duration
+	// WebsocketPongTimeout is the time the client has to answer a ping. If zero, WebsocketPingInterval is used.
+	WebsocketPongTimeout time.Duration
+	// AsyncWriter enables a dedicated writer goroutine for every attached receiver. Messages and frames are queued
+	// and written by that goroutine, so Session.Send, heartbeats and Close never block on slow network I/O.
+	// Write errors close the session and are available from Session.Err (wrapped in *CloseError).
+	// By default writes are performed synchronously by the caller.
+	AsyncWriter bool
 	// In order to keep proxies and load balancers from closing long running http requests we need to pretend that the connection is active
 	// and send a heartbeat packet once in a while. This setting controls how often this is done.
 	// By default a heartbeat packet is sent every 25 seconds.
 	HeartbeatDelay time.Duration
+	// HeartbeatJitter shortens every heartbeat delay by a random duration up to the jitter, so that heartbeats
+	// of many sessions do not fire at the same time. A zero value (default) sends heartbeats exactly every HeartbeatDelay.
+	HeartbeatJitter time.Duration
+	// TimerWheelTick makes sessions schedule heartbeats, disconnect, idle and lifetime timeouts and websocket pings
+	// on timer wheels shared by the handler (one per processor) instead of runtime timers owned by every session.
+	// The wheels fire timers with the tick resolution and greatly reduce timer churn with many sessions. Coalescing
+	// window (see SendCoalesceWindow), usually shorter than the tick, is not scheduled on the wheels.
+	// A zero value (default) disables the wheels. 100ms is a reasonable tick.
+	TimerWheelTick time.Duration
 	// The server closes a session when a client receiving connection have not been seen for a while.
 	// This delay is configured by this setting.
 	// By default the session is closed when a receiving connection wasn't seen for 5 seconds.
@@ -68,6 +107,65 @@ type Options struct {
 	// won't be set at all. If this function is nil then Origin option above will
 	// be taken into account.
 	CheckOrigin func(*http.Request) bool
+	// StrictSendOrigin rejects jsonp_send requests coming from other sites with 403 Forbidden. The origin is taken
+	// from Origin header, or Referer if Origin is missing, and must match the requested host, the Origin option
+	// above, or be accepted by CheckOrigin. Requests carrying neither header are rejected.
+	// By default the origin of jsonp_send requests is not checked.
+	StrictSendOrigin bool
+	// CSRFTokens issues a random token to every session when it is created (see Session.CSRFToken). Send requests
+	// (xhr_send and jsonp_send) have to present the token in X-CSRF-Token header or "csrf" query parameter,
+	// otherwise they are rejected with 403 Forbidden. The application is responsible for passing the token
+	// to the client, i.e. as the first message of the session. Websocket transports are not affected.
+	CSRFTokens bool
+	// ContentSecurityPolicy is set as Content-Security-Policy header of iframe and htmlfile responses, if not empty.
+	ContentSecurityPolicy string
+
+	// MaxSessions limits the number of concurrently open sessions in the handler. New sessions over the limit
+	// are refused: websocket handshakes fail with 503 Service Unavailable and other transports receive
+	// close frame c[1013,"Session limit reached"]. A zero value means no limit.
+	MaxSessions int
+	// MaxSessionsPerIP limits the number of concurrently open sessions from a single client address.
+	// Refused sessions are handled the same way as with MaxSessions. A zero value means no limit.
+	MaxSessionsPerIP int
+	// AdmitSession, if set, decides whether a new session is admitted. It gets the request that would create
+	// the session and the current handler load, including whether the limits above would be exceeded.
+	// Returning true admits the session even over the limits (i.e. for priority users), returning false refuses it
+	// (i.e. on a draining node). The function is called without internal locks held, sessions admitted concurrently
+	// may not be counted in the load it gets yet.
+	AdmitSession func(*http.Request, Admission) bool
+	// KeepSessionOnHandlerReturn keeps the session open after the handler function returns. The session then stays
+	// open until the client goes away or DisconnectDelay expires. By default the session is closed as soon as
+	// the handler function returns.
+	KeepSessionOnHandlerReturn bool
+	// HandlerReturnCloseCode and HandlerReturnCloseReason are sent to the client when the session is closed
+	// because the handler function returned. If the code is zero, code 3000 with reason "Handler finished" is used.
+	HandlerReturnCloseCode   uint32
+	HandlerReturnCloseReason string
+	// OnHandlerPanic is called when a handler function panics. It gets the affected session, the value passed
+	// to panic and the stack trace. Panics are always recovered and only the affected session is closed
+	// with code 1011. If nil, the panic is logged using the standard log package.
+	OnHandlerPanic func(s Session, recovered interface{}, stack []byte)
+	// IdleTimeout closes the session if no message is received from the client for the given duration. Heartbeats
+	// and polling requests do not count as activity. A zero value (default) disables the timeout.
+	IdleTimeout time.Duration
+	// IdleTimeoutCloseCode and IdleTimeoutCloseReason are sent to the client when the session is closed because
+	// of IdleTimeout. If the code is zero, code 3001 with reason "Session idle" is used.
+	IdleTimeoutCloseCode   uint32
+	IdleTimeoutCloseReason string
+	// MaxSessionLifetime closes the session the given duration after it was created, regardless of its activity.
+	// Use Session.ExtendLifetime to prolong the lifetime, i.e. after the client refreshed its credentials.
+	// A zero value (default) means sessions live until closed otherwise.
+	MaxSessionLifetime time.Duration
+	// MaxSessionLifetimeCloseCode and MaxSessionLifetimeCloseReason are sent to the client when the session is closed
+	// because its lifetime expired. If the code is zero, code 3002 with reason "Session lifetime exceeded" is used.
+	MaxSessionLifetimeCloseCode   uint32
+	MaxSessionLifetimeCloseReason string
+	// TrustedProxies is a list of proxy addresses in CIDR notation (or plain IP addresses) whose forwarding headers
+	// (Forwarded, X-Forwarded-For and X-Real-IP) are trusted to carry the real client address. The resolved address
+	// is used for MaxSessionsPerIP, passed to AdmitSession and available as Session.RemoteAddr.
+	// By default no proxies are trusted and the address of the connection peer is used. Invalid entries are logged
+	// using the standard log package and not trusted.
+	TrustedProxies []string
 
 	// DisableXHR This option can be used to restrict handler to use XHR method. By default, DisableXHR is false, meaning that handler is allowed to use XHR
 	DisableXHR bool
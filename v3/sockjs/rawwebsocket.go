@@ -8,54 +8,86 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const unsupportedProtocolMessage = "Unsupported websocket subprotocol"
+
 func (h *Handler) rawWebsocket(rw http.ResponseWriter, req *http.Request) {
+	protocol, handlerFunc, ok := h.negotiateProtocol(req)
+	if !ok {
+		http.Error(rw, unsupportedProtocolMessage, http.StatusBadRequest)
+		return
+	}
+	release, err := h.admit(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
 	upgrader := h.options.WebsocketUpgrader
 	if upgrader == nil {
 		upgrader = new(websocket.Upgrader)
 	}
+	if protocol != "" {
+		negotiated := *upgrader
+		negotiated.Subprotocols = []string{protocol}
+		upgrader = &negotiated
+	}
 	conn, err := upgrader.Upgrade(rw, req, nil)
 	if err != nil {
 		return
 	}
 
 	sessID := ""
-	sess := newSession(req, sessID, h.options.DisconnectDelay, h.options.HeartbeatDelay)
+	sess := h.createSession(req, sessID)
+	h.trackConn(sess)
+	defer h.sessions.removeConn(sess)
 	sess.raw = true
+	sess.protocol = conn.Subprotocol()
+	if handlerFunc != nil {
+		// subprotocol handler function takes precedence over EventHandler
+		sess.handlerFunc = handlerFunc
+		sess.events = nil
+	}
 
 	receiver := newRawWsReceiver(conn, h.options.WebsocketWriteTimeout)
 	if err := sess.attachReceiver(receiver); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if h.handlerFunc != nil {
-		go h.handlerFunc(Session{sess})
-	}
-	readCloseCh := make(chan struct{})
-	go func() {
-		for {
-			frameType, p, err := conn.ReadMessage()
-			if err != nil {
-				close(readCloseCh)
-				return
+	h.startHandler(sess)
+	pinger := h.startWsPinger(conn, sess)
+	defer pinger.stop()
+	// read in the goroutine serving the request, closing the receiver closes the connection and ends the loop
+	for {
+		frameType, p, err := conn.ReadMessage()
+		if err != nil {
+			if receiver.canSend() { // not closed by the server
+				closeOnReadError(sess, err)
 			}
-			if frameType == websocket.TextMessage || frameType == websocket.BinaryMessage {
-				if err := sess.accept(string(p)); err != nil {
-					close(readCloseCh)
-					return
-				}
+			break
+		}
+		if frameType == websocket.TextMessage || frameType == websocket.BinaryMessage {
+			if err := sess.accept(string(p)); err != nil {
+				break
 			}
 		}
-	}()
+	}
+	sess.closeWith(&CloseError{Cause: CloseCauseClientDisconnect})
+	_ = conn.Close()
+}
 
-	select {
-	case <-readCloseCh:
-	case <-receiver.doneNotify():
+// negotiateProtocol picks the first subprotocol requested by the client that has a handler function
+// in Options.RawWebsocketProtocols. It returns false if no requested protocol is supported.
+// Without RawWebsocketProtocols any client is accepted and served by the handler function of the Handler.
+func (h *Handler) negotiateProtocol(req *http.Request) (string, func(Session), bool) {
+	if len(h.options.RawWebsocketProtocols) == 0 {
+		return "", nil, true
 	}
-	sess.close()
-	if err := conn.Close(); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
+	for _, protocol := range websocket.Subprotocols(req) {
+		if handlerFunc, ok := h.options.RawWebsocketProtocols[protocol]; ok {
+			return protocol, handlerFunc, true
+		}
 	}
+	return "", nil, false
 }
 
 type rawWsReceiver struct {
@@ -138,11 +170,13 @@ func parseCloseFrame(frame string) (status uint32, reason string, err error) {
 	return
 }
 
+// close closes the connection, which ends the read loop of the websocket handler (idempotent)
 func (w *rawWsReceiver) close() {
 	select {
 	case <-w.closeCh: // already closed
 	default:
 		close(w.closeCh)
+		_ = w.conn.Close()
 	}
 }
 func (w *rawWsReceiver) canSend() bool {
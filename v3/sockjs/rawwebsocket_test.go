@@ -1,6 +1,7 @@
 package sockjs
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -84,7 +85,7 @@ func TestHandler_RawWebSocketTerminationByClient(t *testing.T) {
 	url := "ws" + server.URL[4:]
 	var done = make(chan struct{})
 	h.handlerFunc = func(conn Session) {
-		if _, err := conn.Recv(); err != ErrSessionNotOpen {
+		if _, err := conn.Recv(); !errors.Is(err, ErrSessionNotOpen) {
 			t.Errorf("Recv should fail")
 		}
 		close(done)
@@ -158,3 +159,97 @@ func TestHandler_RawCustomWebSocketCommunication(t *testing.T) {
 	}
 	<-done
 }
+
+func TestHandler_RawWebSocketClientClose(t *testing.T) {
+	cases := []struct {
+		name     string
+		close    func(conn *websocket.Conn)
+		expected ClientClose
+	}{
+		{"close frame", func(conn *websocket.Conn) {
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "logout"))
+		}, ClientClose{Code: 4001, Reason: "logout", Clean: true}},
+		{"connection dropped", func(conn *websocket.Conn) {
+			_ = conn.UnderlyingConn().Close()
+		}, ClientClose{Code: websocket.CloseAbnormalClosure}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newTestHandler()
+			server := httptest.NewServer(http.HandlerFunc(h.rawWebsocket))
+			defer server.Close()
+			sessions := make(chan Session, 1)
+			h.handlerFunc = func(s Session) {
+				_, _ = s.Recv()
+				sessions <- s
+			}
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+			if err != nil {
+				t.Fatalf("websocket dial failed: %v", err)
+			}
+			defer conn.Close()
+			c.close(conn)
+			sess := (<-sessions).(*session)
+			<-sess.Context().Done()
+			if clientClose, ok := sess.ClientClose(); !ok || clientClose != c.expected {
+				t.Errorf("Unexpected client close, got '%+v' expected '%+v'", clientClose, c.expected)
+			}
+			var closeErr *CloseError
+			if !errors.As(sess.Err(), &closeErr) || closeErr.Cause != CloseCauseClientDisconnect {
+				t.Fatalf("Unexpected close error '%v'", sess.Err())
+			}
+			if c.expected.Clean && (closeErr.Code != uint32(c.expected.Code) || closeErr.Reason != c.expected.Reason) {
+				t.Errorf("Close error should carry client's close code, got '%+v'", closeErr)
+			}
+		})
+	}
+}
+
+func TestHandler_RawWebSocketProtocols(t *testing.T) {
+	h := newTestHandler()
+	sessions := make(chan Session, 1)
+	h.handlerFunc = func(s Session) { t.Errorf("Default handler function should not be used") }
+	h.options.RawWebsocketProtocols = map[string]func(Session){
+		"v1.json":  func(s Session) { t.Errorf("Handler of not negotiated protocol should not be used") },
+		"v2.proto": func(s Session) { sessions <- s },
+	}
+	server := httptest.NewServer(http.HandlerFunc(h.rawWebsocket))
+	defer server.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{"v3.unknown", "v2.proto", "v1.json"}}
+	conn, _, err := dialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "v2.proto" {
+		t.Errorf("Unexpected negotiated protocol, got '%s' expected '%s'", conn.Subprotocol(), "v2.proto")
+	}
+	select {
+	case sess := <-sessions:
+		if protocol := sess.Protocol(); protocol != "v2.proto" {
+			t.Errorf("Unexpected session protocol, got '%s' expected '%s'", protocol, "v2.proto")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Handler function of negotiated protocol should be called")
+	}
+}
+
+func TestHandler_RawWebSocketUnsupportedProtocol(t *testing.T) {
+	h := newTestHandler()
+	h.options.RawWebsocketProtocols = map[string]func(Session){"v1.json": func(s Session) {}}
+	server := httptest.NewServer(http.HandlerFunc(h.rawWebsocket))
+	defer server.Close()
+	for _, protocols := range [][]string{nil, {"v2.proto"}} {
+		dialer := websocket.Dialer{Subprotocols: protocols}
+		_, resp, err := dialer.Dial("ws"+server.URL[4:], nil)
+		if err == nil {
+			t.Fatalf("Client requesting '%v' should be rejected", protocols)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Unexpected response code, got '%d', expected '%d'", resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if stats := h.Stats(); stats.OpenSessions != 0 {
+		t.Errorf("Rejected clients should not open sessions, got '%d'", stats.OpenSessions)
+	}
+}
@@ -1,5 +1,11 @@
 package sockjs
 
+import "errors"
+
+// errReceiverClosed is returned by writes to a receiver that already ended, i.e. a polling request
+// that got its response. Messages not written are sent by the next receiver attached to the session.
+var errReceiverClosed = errors.New("sockjs: receiver closed")
+
 type ReceiverType int
 
 const (
@@ -13,6 +19,21 @@ const (
 	ReceiverTypeWebsocket
 )
 
+// ReceiverConflictPolicy tells what happens when a receiver (i.e. xhr_streaming request) attaches
+// to a session that already has one attached.
+type ReceiverConflictPolicy int
+
+const (
+	// ReceiverConflictReject keeps the attached receiver and closes the new one with
+	// c[2010,"Another connection still open"]. This is the default.
+	ReceiverConflictReject ReceiverConflictPolicy = iota
+	// ReceiverConflictTakeover closes the attached receiver with c[2010,"Another connection still open"]
+	// and attaches the new one. Messages not yet written
+	// by the old receiver are sent by the new one. Useful when clients reconnect before the server notices
+	// that the old connection is dead.
+	ReceiverConflictTakeover
+)
+
 type receiver interface {
 	// sendBulk send multiple data messages in frame frame in format: a["msg 1", "msg 2", ....]
 	sendBulk(...string) error
@@ -0,0 +1,151 @@
+package sockjs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestInfo is a snapshot of the HTTP request that created the session. Unlike Session.Request, which
+// returns the latest request and changes with every poll, RequestInfo never changes during session lifetime.
+// Header and Query are copies owned by the session and must not be modified.
+type RequestInfo struct {
+	Method     string
+	URL        url.URL
+	Host       string
+	Header     http.Header
+	Query      url.Values
+	RemoteAddr string
+	TLS        *tls.ConnectionState
+}
+
+func newRequestInfo(req *http.Request) RequestInfo {
+	if req == nil {
+		return RequestInfo{}
+	}
+	info := RequestInfo{
+		Method:     req.Method,
+		Host:       req.Host,
+		Header:     req.Header.Clone(),
+		RemoteAddr: req.RemoteAddr,
+	}
+	if req.URL != nil {
+		info.URL = *req.URL
+		info.URL.User = nil
+		info.Query = req.URL.Query()
+	}
+	if req.TLS != nil {
+		state := *req.TLS
+		info.TLS = &state
+	}
+	return info
+}
+
+// trustedProxies is a list of networks whose forwarding headers are trusted
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses CIDR notation or plain IP addresses. Invalid entries are left out
+// and reported in the returned error.
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	var nets trustedProxies
+	var errs []error
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sockjs: invalid trusted proxy %q: %w", proxy, err))
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, errors.Join(errs...)
+}
+
+func (t trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range t {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the address of the client. If the request comes from a trusted proxy the forwarding
+// chain (Forwarded, or X-Forwarded-For if the former is missing) is walked from the right, skipping trusted
+// proxies, and the first untrusted address is returned. X-Real-IP is used if the request carries no chain.
+// Walking stops at a hop that is not an IP address (i.e. obfuscated identifier or "unknown").
+func (t trustedProxies) clientIP(req *http.Request) string {
+	addr := clientIP(req)
+	if len(t) == 0 || !t.trusts(addr) {
+		return addr
+	}
+	hops := forwardedHops(req.Header)
+	if len(hops) == 0 {
+		if ip, ok := hopIP(req.Header.Get("X-Real-IP")); ok {
+			return ip
+		}
+		return addr
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := hopIP(hops[i])
+		if !ok {
+			break
+		}
+		addr = ip
+		if !t.trusts(ip) {
+			break
+		}
+	}
+	return addr
+}
+
+// forwardedHops returns addresses of the forwarding chain, the client first
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	if forwarded := header.Values("Forwarded"); len(forwarded) > 0 {
+		// RFC 7239: Forwarded: for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+		return hops
+	}
+	for _, hop := range strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",") {
+		if hop = strings.TrimSpace(hop); hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// hopIP normalizes forwarded address to plain IP, stripping port and IPv6 brackets
+func hopIP(hop string) (string, bool) {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	ip := net.ParseIP(hop)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
@@ -0,0 +1,141 @@
+package sockjs
+
+import (
+	"net/http"
+	"strings"
+)
+
+// sessionHandlerFunc serves a session transport, sessionID is parsed from the request path by the router
+type sessionHandlerFunc func(rw http.ResponseWriter, req *http.Request, sessionID string)
+
+// route handles one http method of an endpoint, chain runs before the session handler (if any)
+type route struct {
+	method  string
+	chain   []http.HandlerFunc
+	handler sessionHandlerFunc
+}
+
+// endpoint holds routes of a path, allow is the precomputed Allow header of 405 responses
+type endpoint struct {
+	routes []route
+	allow  string
+}
+
+func (e *endpoint) add(method string, handler sessionHandlerFunc, chain ...http.HandlerFunc) {
+	e.routes = append(e.routes, route{method: method, chain: chain, handler: handler})
+	if e.allow != "" {
+		e.allow += ", "
+	}
+	e.allow += method
+}
+
+func (e *endpoint) serve(rw http.ResponseWriter, req *http.Request, sessionID string) {
+	for i := range e.routes {
+		r := &e.routes[i]
+		if r.method != req.Method {
+			continue
+		}
+		for _, hf := range r.chain {
+			hf(rw, req)
+		}
+		if r.handler != nil {
+			r.handler(rw, req, sessionID)
+		}
+		return
+	}
+	rw.Header().Set("allow", e.allow)
+	rw.Header().Set("Content-Type", "")
+	rw.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// router dispatches requests to endpoints built once by NewHandler. The path (without handler prefix)
+// is split once into server ID, session ID and transport; no regular expressions are involved.
+type router struct {
+	static     map[string]*endpoint // endpoints without session: welcome, info and raw websocket
+	iframe     *endpoint
+	transports map[string]*endpoint // session endpoints by transport name
+}
+
+func newRouter() router {
+	return router{
+		static:     make(map[string]*endpoint),
+		iframe:     new(endpoint),
+		transports: make(map[string]*endpoint),
+	}
+}
+
+// path returns endpoint of a static path, creating it if needed
+func (r *router) path(path string) *endpoint {
+	e, ok := r.static[path]
+	if !ok {
+		e = new(endpoint)
+		r.static[path] = e
+	}
+	return e
+}
+
+// transport returns session endpoint of a transport, creating it if needed
+func (r *router) transport(name string) *endpoint {
+	e, ok := r.transports[name]
+	if !ok {
+		e = new(endpoint)
+		r.transports[name] = e
+	}
+	return e
+}
+
+// match returns endpoint serving the path and the session ID parsed from it, nil endpoint if there is none
+func (r *router) match(path string) (*endpoint, string) {
+	if e, ok := r.static[path]; ok {
+		return e, ""
+	}
+	if isIframePath(path) {
+		return r.iframe, ""
+	}
+	if _, sessionID, transport, ok := splitSessionPath(path); ok {
+		if e, ok := r.transports[transport]; ok {
+			return e, sessionID
+		}
+	}
+	return nil, ""
+}
+
+// splitSessionPath splits "/<server>/<session>/<transport>" path. Server and session IDs must be non-empty
+// and must not contain dots.
+func splitSessionPath(path string) (serverID, sessionID, transport string, ok bool) {
+	if len(path) == 0 || path[0] != '/' {
+		return "", "", "", false
+	}
+	rest := path[1:]
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return "", "", "", false
+	}
+	serverID, rest = rest[:i], rest[i+1:]
+	if i = strings.IndexByte(rest, '/'); i < 0 {
+		return "", "", "", false
+	}
+	sessionID, transport = rest[:i], rest[i+1:]
+	if !validPathID(serverID) || !validPathID(sessionID) || strings.IndexByte(transport, '/') >= 0 {
+		return "", "", "", false
+	}
+	return serverID, sessionID, transport, true
+}
+
+func validPathID(id string) bool {
+	return id != "" && strings.IndexByte(id, '.') < 0
+}
+
+// isIframePath matches "/iframe[0-9-.a-z_]*.html" paths
+func isIframePath(path string) bool {
+	const prefix, suffix = "/iframe", ".html"
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) || len(path) < len(prefix)+len(suffix) {
+		return false
+	}
+	for _, c := range []byte(path[len(prefix) : len(path)-len(suffix)]) {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package sockjs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSessionID adapts session transport handler for tests that call it directly, session ID is taken from the request path
+func withSessionID(f sessionHandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		_, sessionID, _, _ := splitSessionPath(req.URL.Path)
+		f(rw, req, sessionID)
+	}
+}
+
+func TestSplitSessionPath(t *testing.T) {
+	cases := []struct {
+		path                       string
+		server, session, transport string
+		ok                         bool
+	}{
+		{"/server/session/xhr", "server", "session", "xhr", true},
+		{"/000/abc-def/xhr_send", "000", "abc-def", "xhr_send", true},
+		{"/server/session/", "server", "session", "", true},
+		{"/server/session", "", "", "", false},
+		{"/server//xhr", "", "", "", false},
+		{"//session/xhr", "", "", "", false},
+		{"/server/ses.sion/xhr", "", "", "", false},
+		{"/ser.ver/session/xhr", "", "", "", false},
+		{"/server/session/xhr/more", "", "", "", false},
+		{"server/session/xhr", "", "", "", false},
+		{"", "", "", "", false},
+	}
+	for _, c := range cases {
+		server, session, transport, ok := splitSessionPath(c.path)
+		if server != c.server || session != c.session || transport != c.transport || ok != c.ok {
+			t.Errorf("Unexpected split of '%s', got (%q, %q, %q, %v)", c.path, server, session, transport, ok)
+		}
+	}
+}
+
+func TestIsIframePath(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/iframe.html":           true,
+		"/iframe-1.0.3.html":     true,
+		"/iframe_a-b.html":       true,
+		"/iframe-A.html":         false,
+		"/iframe.htm":            false,
+		"/iframe":                false,
+		"/iframe/x.html":         false,
+		"/server/iframe.html":    false,
+		"/iframe.html/websocket": false,
+	} {
+		assert.Equal(t, expected, isIframePath(path), path)
+	}
+}
+
+func TestHandler_Routing(t *testing.T) {
+	opts := testOptions
+	opts.RawWebsocket = true
+	opts.DisableJSONP = true
+	h := NewHandler("/echo", opts, nil)
+
+	cases := []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{"GET", "/echo", http.StatusOK, ""},
+		{"GET", "/echo/", http.StatusOK, ""},
+		{"POST", "/echo/", http.StatusMethodNotAllowed, "GET"},
+		{"GET", "/echo/info", http.StatusOK, ""},
+		{"POST", "/echo/info", http.StatusMethodNotAllowed, "OPTIONS, GET"},
+		{"GET", "/echo/iframe-1.0.html", http.StatusOK, ""},
+		{"GET", "/echo/server/session/xhr", http.StatusMethodNotAllowed, "POST, OPTIONS"},
+		{"GET", "/echo/server/session/xhr_send", http.StatusMethodNotAllowed, "POST, OPTIONS"},
+		{"OPTIONS", "/echo/server/session/xhr_send", http.StatusNoContent, ""},
+		{"POST", "/echo/server/session/websocket", http.StatusMethodNotAllowed, "GET"},
+		{"POST", "/echo/websocket", http.StatusMethodNotAllowed, "GET"},
+		{"GET", "/echo/server/session/jsonp", http.StatusNotFound, ""},
+		{"GET", "/echo/server/session/unknown", http.StatusNotFound, ""},
+		{"GET", "/echo/server/ses.sion/xhr", http.StatusNotFound, ""},
+		{"GET", "/other/info", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(c.method, c.path, nil))
+		assert.Equal(t, c.code, rw.Code, "%s %s", c.method, c.path)
+		assert.Equal(t, c.allow, rw.Header().Get("allow"), "%s %s", c.method, c.path)
+	}
+}
+
+func TestHandler_RoutingPassesSessionID(t *testing.T) {
+	h := newTestHandler()
+	h.prefix = "/echo"
+	sessionIDs := make(chan string, 1)
+	paths := make(chan string, 1)
+	r := newRouter()
+	r.transport("xhr").add("POST", func(rw http.ResponseWriter, req *http.Request, sessionID string) {
+		sessionIDs <- sessionID
+		paths <- req.URL.Path
+	})
+	h.router = r
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/echo/server/abc/xhr", nil)
+	h.ServeHTTP(rw, req)
+	assert.Equal(t, "abc", <-sessionIDs)
+	assert.Equal(t, "/server/abc/xhr", <-paths, "transports should get the path without prefix")
+	assert.Equal(t, "/echo/server/abc/xhr", req.URL.Path, "request of the caller should not be modified")
+}
+
+func TestHandler_SessionRequestWithoutPrefix(t *testing.T) {
+	h := NewHandler("/echo", testOptions, nil)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("POST", "/echo/server/session/xhr", nil))
+	sess, ok := h.sessions.get("session")
+	require.True(t, ok)
+	defer sess.close()
+	assert.Equal(t, "/server/session/xhr", sess.Request().URL.Path)
+	assert.Equal(t, "/server/session/xhr", sess.InitialRequest().URL.Path)
+}
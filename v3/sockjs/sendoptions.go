@@ -0,0 +1,147 @@
+package sockjs
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrMessageExpired is returned by SendCtx if the message was dropped because its time-to-live
+// (see WithTTL) passed before it could be written
+var ErrMessageExpired = errors.New("sockjs: message expired before it was written")
+
+// Priority of an outbound message, see WithPriority. Messages waiting in the send buffer (i.e. for a polling
+// client to reconnect) are written in order of priority, higher first. Messages of the same priority keep
+// the order they were sent in.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0 // priority of messages sent without WithPriority
+	PriorityHigh   Priority = 1
+)
+
+// SendOption configures a message sent by Session.Send or Session.SendCtx
+type SendOption func(*messageMeta)
+
+// WithPriority sets priority of the message
+func WithPriority(p Priority) SendOption {
+	return func(m *messageMeta) { m.priority = p }
+}
+
+// WithTTL sets time-to-live of the message. The message is dropped if it is not written within ttl,
+// i.e. stale updates buffered while a polling client is away.
+func WithTTL(ttl time.Duration) SendOption {
+	return func(m *messageMeta) { m.expires = time.Now().Add(ttl) }
+}
+
+// messageMeta holds send options of a buffered message
+type messageMeta struct {
+	priority Priority
+	expires  time.Time   // zero if the message does not expire
+	key      string      // conflation key, see SendConflated
+	status   *sendStatus // set for messages waited for by SendCtx
+}
+
+// sendStatus tells SendCtx what happened to its message
+type sendStatus struct {
+	expired bool
+}
+
+func newMessageMeta(opts []SendOption) *messageMeta {
+	if len(opts) == 0 {
+		return nil
+	}
+	meta := new(messageMeta)
+	for _, opt := range opts {
+		opt(meta)
+	}
+	return meta
+}
+
+// appendMetaLocked records options of the message just appended to send buffer. Metadata are kept
+// only once a message with options gets buffered, until the buffer is written.
+func (s *session) appendMetaLocked(meta *messageMeta) {
+	if meta == nil && s.sendMeta == nil {
+		return
+	}
+	if s.sendMeta == nil {
+		s.sendMeta = make([]messageMeta, len(s.sendBuffer)-1, cap(s.sendBuffer))
+	}
+	if meta == nil {
+		meta = &messageMeta{}
+	}
+	s.sendMeta = append(s.sendMeta, *meta)
+	if meta.key != "" && s.conflated != nil {
+		s.conflated[meta.key] = len(s.sendMeta) - 1
+	}
+}
+
+// conflateLocked replaces buffered message with the same conflation key, it returns false if there is none
+func (s *session) conflateLocked(msg string, meta *messageMeta) bool {
+	if meta == nil || meta.key == "" || s.sendMeta == nil {
+		return false
+	}
+	if s.conflated == nil {
+		// (re)built lazily, positions change once the buffer gets reordered
+		s.indexConflatedLocked()
+	}
+	i, ok := s.conflated[meta.key]
+	if !ok {
+		return false
+	}
+	replaced := *meta
+	if replaced.status == nil {
+		// the waiter of the replaced message learns whether its replacement expired
+		replaced.status = s.sendMeta[i].status
+	}
+	s.sendBuffer[i], s.sendMeta[i] = msg, replaced
+	return true
+}
+
+// indexConflatedLocked builds positions of buffered messages by conflation key
+func (s *session) indexConflatedLocked() {
+	s.conflated = make(map[string]int)
+	for i, m := range s.sendMeta {
+		if m.key != "" {
+			s.conflated[m.key] = i
+		}
+	}
+}
+
+// prioritizeLocked drops expired messages from send buffer and orders the rest by priority
+func (s *session) prioritizeLocked() {
+	if s.sendMeta == nil {
+		return
+	}
+	now := time.Now()
+	kept := 0
+	for i, meta := range s.sendMeta {
+		if !meta.expires.IsZero() && now.After(meta.expires) {
+			if meta.status != nil {
+				meta.status.expired = true
+			}
+			continue
+		}
+		s.sendBuffer[kept], s.sendMeta[kept] = s.sendBuffer[i], meta
+		kept++
+	}
+	for i := kept; i < len(s.sendBuffer); i++ {
+		s.sendBuffer[i], s.sendMeta[i] = "", messageMeta{}
+	}
+	s.sendBuffer, s.sendMeta = s.sendBuffer[:kept], s.sendMeta[:kept]
+	sort.Stable(byPriority{s.sendBuffer, s.sendMeta})
+	s.conflated = nil
+}
+
+type byPriority struct {
+	messages []string
+	meta     []messageMeta
+}
+
+func (b byPriority) Len() int           { return len(b.messages) }
+func (b byPriority) Less(i, j int) bool { return b.meta[i].priority > b.meta[j].priority }
+func (b byPriority) Swap(i, j int) {
+	b.messages[i], b.messages[j] = b.messages[j], b.messages[i]
+	b.meta[i], b.meta[j] = b.meta[j], b.meta[i]
+}
@@ -0,0 +1,159 @@
+package sockjs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_SendPriorities(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	require.NoError(t, sess.Send("chatter", WithPriority(PriorityLow)))
+	require.NoError(t, sess.Send("tick 1"))
+	require.NoError(t, sess.Send("alert", WithPriority(PriorityHigh)))
+	require.NoError(t, sess.Send("tick 2"))
+
+	recv := newTestReceiver()
+	require.NoError(t, sess.attachReceiver(recv))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"o", "alert", "tick 1", "tick 2", "chatter"}, recv.frames)
+	assert.Nil(t, sess.sendMeta)
+}
+
+func TestSession_SendWithoutOptionsKeepsNoMetadata(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	require.NoError(t, sess.Send("a"))
+	require.NoError(t, sess.Send("b"))
+	assert.Nil(t, sess.sendMeta)
+	require.NoError(t, sess.Send("c", WithPriority(PriorityNormal)))
+	assert.Len(t, sess.sendMeta, 3)
+}
+
+func TestSession_SendTTL(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	require.NoError(t, sess.Send("stale", WithTTL(time.Millisecond)))
+	require.NoError(t, sess.Send("fresh", WithTTL(time.Hour)))
+	require.NoError(t, sess.Send("forever"))
+	time.Sleep(5 * time.Millisecond)
+
+	recv := newTestReceiver()
+	require.NoError(t, sess.attachReceiver(recv))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"o", "fresh", "forever"}, recv.frames)
+}
+
+func TestSession_SendCtxExpired(t *testing.T) {
+	for _, asyncWriter := range []bool{false, true} {
+		sess := newTestSession()
+		sess.asyncWriter = asyncWriter
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = sess.attachReceiver(newTestReceiver())
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		assert.Equal(t, ErrMessageExpired, sess.SendCtx(ctx, "stale", WithTTL(time.Millisecond)), "async writer: %v", asyncWriter)
+		assert.NoError(t, sess.SendCtx(ctx, "fresh", WithTTL(time.Hour)), "async writer: %v", asyncWriter)
+		cancel()
+		sess.close()
+	}
+}
+
+func TestSession_SendConflated(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	require.NoError(t, sess.SendConflated("btc", "btc 1"))
+	require.NoError(t, sess.Send("news"))
+	require.NoError(t, sess.SendConflated("btc", "btc 2"))
+	require.NoError(t, sess.SendConflated("eth", "eth 1"))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, sess.SendConflated("eth", fmt.Sprintf("eth %d", i)))
+	}
+	assert.Len(t, sess.sendBuffer, 3, "conflated messages should keep the buffer bounded")
+
+	recv := newTestReceiver()
+	require.NoError(t, sess.attachReceiver(recv))
+	require.NoError(t, sess.SendConflated("btc", "btc 3"))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"o", "btc 2", "news", "eth 99", "btc 3"}, recv.frames)
+}
+
+func TestSession_SendConflatedAfterReorder(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	require.NoError(t, sess.SendConflated("key", "old", WithPriority(PriorityLow)))
+	require.NoError(t, sess.Send("alert", WithPriority(PriorityHigh)))
+	sess.mux.Lock()
+	sess.prioritizeLocked() // as if writing the buffer failed
+	sess.mux.Unlock()
+	require.NoError(t, sess.SendConflated("key", "new"))
+	assert.Equal(t, []string{"alert", "new"}, sess.sendBuffer)
+}
+
+func TestSession_RequeueKeepsSendOptions(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.asyncWriter = true
+	sess.conflictPolicy = ReceiverConflictTakeover
+	old := newBlockingReceiver(nil)
+	defer close(old.release)
+	require.NoError(t, sess.attachReceiver(old))
+	<-old.writing // writer is stuck on the open frame, messages below stay queued
+	require.NoError(t, sess.Send("chatter", WithPriority(PriorityLow)))
+	require.NoError(t, sess.Send("tick"))
+	require.NoError(t, sess.Send("alert", WithPriority(PriorityHigh)))
+	require.NoError(t, sess.Send("stale", WithTTL(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+
+	sess.asyncWriter = false
+	recv := newTestReceiver()
+	require.NoError(t, sess.attachReceiver(recv))
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Equal(t, []string{"alert", "tick", "chatter"}, recv.frames)
+}
+
+func TestSession_SendConflatedAfterRequeue(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	sess.asyncWriter = true
+	sess.conflictPolicy = ReceiverConflictTakeover
+	old := newBlockingReceiver(nil)
+	defer close(old.release)
+	require.NoError(t, sess.attachReceiver(old))
+	<-old.writing
+	require.NoError(t, sess.SendConflated("btc", "btc 1"))
+	require.NoError(t, sess.Send("news"))
+
+	sess.mux.Lock()
+	sess.takeoverLocked()
+	sess.mux.Unlock()
+	require.NoError(t, sess.SendConflated("btc", "btc 2"))
+	assert.Equal(t, []string{"btc 2", "news"}, sess.sendBuffer)
+}
+
+func TestSession_SendConflatedKeepsWaiterStatus(t *testing.T) {
+	sess := newTestSession()
+	defer sess.close()
+	status := new(sendStatus)
+	sess.mux.Lock()
+	// a message waited for like by SendCtx
+	require.NoError(t, sess.sendMessageLocked("btc 1", true, &messageMeta{key: "btc", expires: time.Now().Add(time.Hour), status: status}))
+	seq := sess.sendSeq
+	sess.mux.Unlock()
+	require.NoError(t, sess.SendConflated("btc", "btc 2", WithTTL(-time.Second)))
+
+	require.NoError(t, sess.attachReceiver(newTestReceiver()))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, ErrMessageExpired, sess.waitDelivered(ctx, seq, status))
+}
@@ -3,8 +3,11 @@ package sockjs
 import (
 	"context"
 	"errors"
+	"iter"
+	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,47 +28,162 @@ const (
 var (
 	// ErrSessionNotOpen error is used to denote session not in open state.
 	// Recv() and Send() operations are not supported if session is closed.
-	ErrSessionNotOpen          = errors.New("sockjs: session not in open state")
-	errSessionReceiverAttached = errors.New("sockjs: another receiver already attached")
-	errSessionParse            = errors.New("sockjs: unable to parse URL for session")
+	ErrSessionNotOpen = errors.New("sockjs: session not in open state")
+	// errSessionReceiverAttached closes a receiver rejected or taken over because of another one, see ReceiverConflictPolicy
+	errSessionReceiverAttached = &CloseError{Code: 2010, Reason: "Another connection still open", Cause: CloseCauseReceiverConflict}
 )
 
-type Session struct {
-	*session
+// Session is a sockjs connection served by a handler function or an EventHandler. It is an interface so that
+// handlers can be unit tested with fake sessions and sessions can be wrapped with decorators (logging, metrics).
+// A decorator embedding a Session keeps all its features and overrides only the methods it needs to.
+type Session interface {
+	// ID returns a session id
+	ID() string
+	// Request returns the latest http request of the session
+	Request() *http.Request
+	// Recv reads one text frame from session
+	Recv() (string, error)
+	// RecvCtx reads one text frame from session, it returns ctx error if ctx is done first
+	RecvCtx(ctx context.Context) (string, error)
+	// Send sends one text frame to session, with options (priority, time-to-live)
+	Send(msg string, opts ...SendOption) error
+	// Close closes the session with provided code and reason
+	Close(status uint32, reason string) error
+	// GetSessionState returns the current state of the session
+	GetSessionState() SessionState
+	// ReceiverType returns receiver used in session
+	ReceiverType() ReceiverType
+	// Context returns session context, it is cancelled once the session gets into closing or closed state
+	Context() context.Context
+	// Messages returns an iterator over messages received from the client, it stops once the session is closed
+	Messages(ctx context.Context) iter.Seq2[string, error]
+	// MessageChan returns a channel of messages received from the client, it is closed once the session is closed,
+	// ctx is done or the returned cancel function is called
+	MessageChan(ctx context.Context) (<-chan string, context.CancelFunc)
+
+	// Err returns nil while the session is open, *CloseError telling why the session ended otherwise
+	Err() error
+	// ClientClose returns the close code and reason sent by websocket client
+	ClientClose() (ClientClose, bool)
+
+	// InitialRequest returns snapshot of the request that created the session
+	InitialRequest() RequestInfo
+	// RemoteAddr returns the client IP address
+	RemoteAddr() string
+	// Stats returns traffic counters of the session
+	Stats() SessionStats
+	// RTT returns the last websocket ping round-trip time
+	RTT() time.Duration
+	// CSRFToken returns token required on send requests
+	CSRFToken() string
+	// Protocol returns negotiated websocket subprotocol
+	Protocol() string
+
+	// ExtendLifetime sets the session to be closed d from now, see Options.MaxSessionLifetime
+	ExtendLifetime(d time.Duration) error
+
+	// SendConflated sends one text frame to session, replacing a frame of the same key still waiting to be sent
+	SendConflated(key, msg string, opts ...SendOption) error
+	// SendCtx sends one text frame to session and waits until it is written to the client
+	SendCtx(ctx context.Context, msg string, opts ...SendOption) error
+	// Flush waits until all frames sent so far are written to the client
+	Flush(ctx context.Context) error
+}
+
+var _ Session = (*session)(nil)
+
+// SessionStats contains traffic counters of a session.
+// Bytes are counted as the length of message payloads, without framing.
+type SessionStats struct {
+	// ReceiverAttachments is the number of receivers (http requests or websocket connections) attached to the session
+	ReceiverAttachments uint64
+	MessagesIn          uint64
+	MessagesOut         uint64
+	BytesIn             uint64
+	BytesOut            uint64
 }
 
 type session struct {
-	mux   sync.RWMutex
-	id    string
-	req   *http.Request
-	state SessionState
+	mux         sync.RWMutex
+	id          string
+	req         *http.Request
+	initialReq  RequestInfo
+	remoteAddr  string
+	state       SessionState
+	attachments atomic.Uint64
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	rtt         atomic.Int64 // last websocket ping round-trip time
 
 	recv         receiver // protocol dependent receiver (xhr, eventsource, ...)
 	receiverType ReceiverType
 	sendBuffer   []string       // messages to be sent to client
+	sendMeta     []messageMeta  // send options of buffered messages, nil unless a message with options is buffered
+	conflated    map[string]int // positions of buffered messages by conflation key, nil if not built
+	// delivery tracking of sent messages (see SendCtx and Flush): sequence of the last message sent
+	// and of the last one written to the wire (or dropped), number of messages handed to an async
+	// receiver but not written yet
+	sendSeq      uint64
+	deliveredSeq uint64
+	inflight     uint64
+	deliveryCh   chan struct{}  // closed on progress of delivery, created by waiters
 	recvBuffer   *messageBuffer // messages received from client to be consumed by application
 	closeFrame   string         // closeFrame to send after session is closed
+	closeErr     *CloseError    // reason why the session was closed, nil while session is open
+	clientClose  *ClientClose   // how websocket client closed the connection
 
+	conflictPolicy ReceiverConflictPolicy
+	// token required on send requests, see Options.CSRFTokens
+	csrfToken string
+	// websocket subprotocol negotiated with the client
+	protocol string
+	// handler function selected by the subprotocol, overrides the one of the Handler
+	handlerFunc func(Session)
+	// callbacks of EventHandler, nil if the session is served by a handler function
+	events *sessionEvents
 	// do not use SockJS framing for raw websocket connections
 	raw bool
+	// write to receivers from a dedicated goroutine instead of the caller's one
+	asyncWriter bool
+
+	// messages sent within this window are written to receiver in one frame
+	sendCoalesceWindow time.Duration
+	flushTimer         *time.Timer
+
+	// application level limits, see Options.IdleTimeout and Options.MaxSessionLifetime
+	idleTimeout         time.Duration
+	idleTimer           sessionTimer
+	lastRecv            atomic.Int64 // unix nanoseconds of the last message received from client
+	idleCloseCode       uint32
+	idleCloseReason     string
+	lifetimeTimer       sessionTimer
+	lifetimeDeadline    time.Time
+	lifetimeCloseCode   uint32
+	lifetimeCloseReason string
 
 	// internal timer used to handle session expiration if no receiver is attached, or heartbeats if recevier is attached
 	sessionTimeoutInterval time.Duration
 	heartbeatInterval      time.Duration
-	timer                  *time.Timer
+	heartbeatJitter        time.Duration
+	timer                  sessionTimer
+	scheduler              scheduler
 	// once the session timeouts this channel also closes
 	closeCh          chan struct{}
+	onClosed         func() // called once the session is closed, i.e. to remove it from the handler
 	startHandlerOnce sync.Once
 	context          context.Context
-	cancelFunc       func()
+	cancelFunc       context.CancelCauseFunc
 }
 
 // session is a central component that handles receiving and sending frames. It maintains internal state
 func newSession(req *http.Request, sessionID string, sessionTimeoutInterval, heartbeatInterval time.Duration) *session {
-	context, cancel := context.WithCancel(context.Background())
+	context, cancel := context.WithCancelCause(context.Background())
 	s := &session{
 		id:                     sessionID,
 		req:                    req,
+		initialReq:             newRequestInfo(req),
 		heartbeatInterval:      heartbeatInterval,
 		recvBuffer:             newMessageBuffer(),
 		closeCh:                make(chan struct{}),
@@ -73,48 +191,141 @@ func newSession(req *http.Request, sessionID string, sessionTimeoutInterval, hea
 		receiverType:           ReceiverTypeNone,
 		context:                context,
 		cancelFunc:             cancel,
+		scheduler:              stdScheduler{},
+	}
+
+	if req != nil {
+		s.remoteAddr = clientIP(req)
 	}
 
 	s.mux.Lock()
-	s.timer = time.AfterFunc(sessionTimeoutInterval, s.close)
+	s.timer = s.scheduler.AfterFunc(sessionTimeoutInterval, s.timeout)
 	s.mux.Unlock()
 	return s
 }
 
-func (s *session) sendMessage(msg string) error {
+// useScheduler makes the session schedule heartbeats and timeouts with sc (i.e. handler's timer wheel).
+// Must be called before the session is used.
+func (s *session) useScheduler(sc scheduler) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	s.timer.Stop()
+	s.scheduler = sc
+	s.timer = sc.AfterFunc(s.sessionTimeoutInterval, s.timeout)
+}
+
+// nextHeartbeat returns the delay of the next heartbeat, shortened by random jitter
+// so that heartbeats of sessions created at the same time spread out
+func (s *session) nextHeartbeat() time.Duration {
+	if s.heartbeatJitter <= 0 || s.heartbeatJitter >= s.heartbeatInterval {
+		return s.heartbeatInterval
+	}
+	return s.heartbeatInterval - time.Duration(rand.Int63n(int64(s.heartbeatJitter)))
+}
+
+// sendMessageLocked buffers the message and writes the buffer to attached receiver, unless the message
+// waits for coalescing window. Immediate sends skip the window. meta holds send options, nil if none.
+func (s *session) sendMessageLocked(msg string, immediate bool, meta *messageMeta) error {
 	if s.state > SessionActive {
-		return ErrSessionNotOpen
+		return s.errLocked()
 	}
-	s.sendBuffer = append(s.sendBuffer, msg)
+	if !s.conflateLocked(msg, meta) {
+		s.sendBuffer = append(s.sendBuffer, msg)
+		s.appendMetaLocked(meta)
+	}
+	s.sendSeq++
 	if s.recv != nil && s.recv.canSend() {
-		if err := s.recv.sendBulk(s.sendBuffer...); err != nil {
-			return err
+		if s.sendCoalesceWindow > 0 && !immediate {
+			if s.flushTimer == nil {
+				s.flushTimer = time.AfterFunc(s.sendCoalesceWindow, s.flush)
+			}
+			return nil
 		}
-		s.sendBuffer = nil
+		return s.sendBuffered()
 	}
 	return nil
 }
 
+// sendBuffered sends all buffered messages to the attached receiver, expired ones are dropped and
+// the rest is ordered by priority
+func (s *session) sendBuffered() error {
+	if len(s.sendBuffer) == 0 {
+		return nil
+	}
+	s.prioritizeLocked()
+	async, isAsync := s.recv.(*asyncReceiver)
+	var err error
+	if isAsync {
+		err = async.sendBulkSeq(s.sendSeq, s.sendBuffer, s.sendMeta)
+	} else if len(s.sendBuffer) > 0 {
+		err = s.recv.sendBulk(s.sendBuffer...)
+	}
+	if err == errReceiverClosed {
+		return nil // receiver ended meanwhile, messages stay buffered for the next one
+	}
+	if err != nil {
+		return err
+	}
+	s.messagesOut.Add(uint64(len(s.sendBuffer)))
+	for _, msg := range s.sendBuffer {
+		s.bytesOut.Add(uint64(len(msg)))
+	}
+	if isAsync {
+		s.inflight += uint64(len(s.sendBuffer))
+	} else {
+		s.deliveredLocked(s.sendSeq)
+	}
+	s.sendBuffer, s.sendMeta, s.conflated = nil, nil, nil
+	return nil
+}
+
+// flush writes messages coalesced in send buffer to the attached receiver
+func (s *session) flush() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.flushTimer = nil
+	s.flushLocked()
+}
+
+func (s *session) flushLocked() {
+	if len(s.sendBuffer) == 0 || s.recv == nil || !s.recv.canSend() {
+		return
+	}
+	_ = s.sendBuffered()
+}
+
+func (s *session) stopFlushTimer() {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+}
+
 func (s *session) attachReceiver(recv receiver) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	if s.recv != nil {
-		return errSessionReceiverAttached
+		if s.conflictPolicy != ReceiverConflictTakeover {
+			return errSessionReceiverAttached
+		}
+		s.takeoverLocked()
+	}
+	if s.asyncWriter {
+		recv = newAsyncReceiver(recv, s.fail, s.settle)
 	}
 	s.recv = recv
 	s.receiverType = recv.receiverType()
-	go func(r receiver) {
-		select {
-		case <-r.doneNotify():
-			s.detachReceiver()
-		case <-r.interruptedNotify():
-			s.detachReceiver()
-			s.close()
-		}
-	}(recv)
+	s.attachments.Add(1)
+	if err := s.startReceiverLocked(); err != nil {
+		// nobody serves a receiver that failed to attach, see serveReceiver
+		s.detachLocked()
+		return err
+	}
+	return nil
+}
 
+// startReceiverLocked sends pending frames to the newly attached receiver and schedules heartbeats
+func (s *session) startReceiverLocked() error {
 	if s.state == SessionClosing {
 		if !s.raw {
 			if err := s.recv.sendFrame(s.closeFrame); err != nil {
@@ -122,6 +333,7 @@ func (s *session) attachReceiver(recv receiver) error {
 			}
 		}
 		s.recv.close()
+		s.detachLocked()
 		return nil
 	}
 	if s.state == SessionOpening {
@@ -132,66 +344,192 @@ func (s *session) attachReceiver(recv receiver) error {
 		}
 		s.state = SessionActive
 	}
-	if err := s.recv.sendBulk(s.sendBuffer...); err != nil {
-		return err
+	if s.recv.canSend() { // polling receivers end with the open frame
+		if err := s.sendBuffered(); err != nil {
+			return err
+		}
 	}
-	s.sendBuffer = nil
 	s.timer.Stop()
 	if s.heartbeatInterval > 0 {
-		s.timer = time.AfterFunc(s.heartbeatInterval, s.heartbeat)
+		s.timer = s.scheduler.AfterFunc(s.nextHeartbeat(), s.heartbeat)
 	}
 	return nil
 }
 
 func (s *session) detachReceiver() {
 	s.mux.Lock()
+	s.detachLocked()
+	s.mux.Unlock()
+}
+
+// serveReceiver blocks until recv ends and detaches it from the session, the session is closed if recv
+// was interrupted (i.e. the client went away). It is called from the goroutine serving receiver's request
+// after the receiver was attached, so that sessions need no watcher goroutines of their own.
+func (s *session) serveReceiver(recv receiver) {
+	select {
+	case <-recv.doneNotify():
+		s.detach(recv)
+	case <-recv.interruptedNotify():
+		if s.detach(recv) {
+			s.closeWith(&CloseError{Cause: CloseCauseClientDisconnect})
+		}
+	}
+}
+
+// detach detaches recv unless it was already replaced by another receiver (see ReceiverConflictTakeover),
+// it returns false in such case
+func (s *session) detach(recv receiver) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.recv != nil && s.recv != recv {
+		if async, ok := s.recv.(*asyncReceiver); !ok || async.receiver != recv {
+			return false
+		}
+	}
+	s.detachLocked()
+	return true
+}
+
+func (s *session) detachLocked() {
 	s.timer.Stop()
-	s.timer = time.AfterFunc(s.sessionTimeoutInterval, s.close)
+	s.timer = s.scheduler.AfterFunc(s.sessionTimeoutInterval, s.timeout)
+	s.recv = nil
+}
+
+// takeoverLocked closes the attached receiver with c[2010,"Another connection still open"] to make room
+// for a new one. Messages not yet written by the old receiver are moved back to the send buffer, so that
+// the new receiver sends them.
+func (s *session) takeoverLocked() {
+	if async, ok := s.recv.(*asyncReceiver); ok {
+		unsent, seq := async.takeover(cFrame)
+		if len(unsent.messages) > 0 {
+			s.requeueLocked(unsent)
+		}
+		if seq > 0 {
+			s.deliveredLocked(seq)
+		}
+	} else {
+		_ = s.recv.sendFrame(cFrame)
+		s.recv.close()
+	}
 	s.recv = nil
-	s.mux.Unlock()
+}
+
+// requeueLocked puts messages handed to async receiver but not written back to the front of send buffer,
+// with their send options
+func (s *session) requeueLocked(unsent messageBatch) {
+	s.messagesOut.Add(^uint64(len(unsent.messages) - 1))
+	for _, msg := range unsent.messages {
+		s.bytesOut.Add(^uint64(len(msg) - 1))
+	}
+	s.inflight -= uint64(len(unsent.messages))
+	unsent.append(s.sendBuffer, s.sendMeta)
+	s.sendBuffer, s.sendMeta = unsent.messages, unsent.meta
+	if s.sendMeta != nil {
+		s.indexConflatedLocked() // positions of messages buffered before moved by the requeued ones
+	}
 }
 
 func (s *session) heartbeat() {
 	s.mux.Lock()
 	if s.recv != nil { // timer could have fired between Lock and timer.Stop in detachReceiver
 		_ = s.recv.sendFrame("h")
-		s.timer = time.AfterFunc(s.heartbeatInterval, s.heartbeat)
+		s.timer.Stop() // heartbeat of a previous receiver could fire after the current one was attached
+		s.timer = s.scheduler.AfterFunc(s.nextHeartbeat(), s.heartbeat)
 	}
 	s.mux.Unlock()
 }
 
 func (s *session) accept(messages ...string) error {
+	if s.idleTimeout > 0 {
+		s.lastRecv.Store(time.Now().UnixNano())
+	}
+	s.messagesIn.Add(uint64(len(messages)))
+	for _, msg := range messages {
+		s.bytesIn.Add(uint64(len(msg)))
+	}
+	if s.events != nil {
+		return s.events.messages(s, messages)
+	}
 	return s.recvBuffer.push(messages...)
 }
 
 // idempotent operation
 func (s *session) closing() {
+	s.closingWith(&CloseError{Cause: CloseCauseServerClose})
+}
+
+// closingWith moves session to closing state, closeErr is recorded only if the reason is not known yet
+func (s *session) closingWith(closeErr *CloseError) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	if s.state < SessionClosing {
 		s.state = SessionClosing
+		if s.closeErr == nil {
+			s.closeErr = closeErr
+		}
 		s.recvBuffer.close()
+		s.stopFlushTimer()
+		s.stopLimitTimers()
 		if s.recv != nil {
+			// messages still waiting for coalescing window must precede close frame
+			s.flushLocked()
 			_ = s.recv.sendFrame(s.closeFrame)
 			s.recv.close()
+			s.detachLocked()
 		}
-		s.cancelFunc()
+		s.deliveredLocked(0) // wake up waiters to give up
+		s.cancelFunc(s.closeErr)
 	}
 }
 
 // idempotent operation
 func (s *session) close() {
-	s.closing()
+	s.closeWith(&CloseError{Cause: CloseCauseServerClose})
+}
+
+// closeWith closes the session, closeErr is recorded only if the reason is not known yet (idempotent operation)
+func (s *session) closeWith(closeErr *CloseError) {
+	s.closingWith(closeErr)
+	var onClosed func()
 	s.mux.Lock()
-	defer s.mux.Unlock()
 	if s.state < SessionClosed {
 		s.state = SessionClosed
 		s.timer.Stop()
+		s.stopFlushTimer()
 		close(s.closeCh)
-		s.cancelFunc()
+		onClosed = s.onClosed
+	}
+	s.mux.Unlock()
+	if onClosed != nil {
+		onClosed()
 	}
 }
 
+// setOnClosed registers a function called once the session gets closed, it is called
+// immediately if the session is closed already
+func (s *session) setOnClosed(f func()) {
+	s.mux.Lock()
+	if s.state < SessionClosed {
+		s.onClosed = f
+		s.mux.Unlock()
+		return
+	}
+	s.mux.Unlock()
+	f()
+}
+
+// timeout closes the session after no receiver was attached for sessionTimeoutInterval
+func (s *session) timeout() {
+	s.closeWith(&CloseError{Cause: CloseCauseTimeout})
+}
+
+// fail closes the session because of an error in underlying receiver.
+// The error is available from Err and as a cause of the session context.
+func (s *session) fail(err error) {
+	s.closeWith(&CloseError{Cause: CloseCauseClientDisconnect, Err: err})
+}
+
 func (s *session) setCurrentRequest(req *http.Request) {
 	s.mux.Lock()
 	s.req = req
@@ -200,15 +538,56 @@ func (s *session) setCurrentRequest(req *http.Request) {
 
 // Close closes the session with provided code and reason.
 func (s *session) Close(status uint32, reason string) error {
+	return s.closeWithStatus(status, reason, CloseCauseServerClose)
+}
+
+func (s *session) closeWithStatus(status uint32, reason string, cause CloseCause) error {
 	s.mux.Lock()
 	if s.state < SessionClosing {
 		s.closeFrame = closeFrame(status, reason)
 		s.mux.Unlock()
-		s.closing()
+		s.closingWith(&CloseError{Code: status, Reason: reason, Cause: cause})
 		return nil
 	}
-	s.mux.Unlock()
-	return ErrSessionNotOpen
+	defer s.mux.Unlock()
+	return s.errLocked()
+}
+
+// Err returns nil while the session is open. Once the session gets into closing or closed state
+// it returns *CloseError telling why the session ended.
+func (s *session) Err() error {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.closeErr == nil {
+		return nil
+	}
+	return s.closeErr
+}
+
+func (s *session) setClientClose(clientClose ClientClose) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.clientClose = &clientClose
+}
+
+// ClientClose returns the close code and reason sent by websocket client, and whether the close handshake
+// completed cleanly. The second return value is false if the client has not closed its websocket connection
+// (yet), or the session does not use websocket transport.
+func (s *session) ClientClose() (ClientClose, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.clientClose == nil {
+		return ClientClose{}, false
+	}
+	return *s.clientClose, true
+}
+
+// errLocked returns the error to be reported by operations on not open session
+func (s *session) errLocked() error {
+	if s.closeErr == nil {
+		return ErrSessionNotOpen
+	}
+	return s.closeErr
 }
 
 // ID returns a session id
@@ -218,20 +597,70 @@ func (s *session) ID() string {
 
 // Recv reads one text frame from session
 func (s *session) Recv() (string, error) {
-	return s.recvBuffer.pop(context.Background())
+	return s.RecvCtx(context.Background())
 }
 
 // RecvCtx reads one text frame from session
 func (s *session) RecvCtx(ctx context.Context) (string, error) {
-	return s.recvBuffer.pop(ctx)
+	msg, err := s.recvBuffer.pop(ctx)
+	if err == ErrSessionNotOpen {
+		s.mux.RLock()
+		err = s.errLocked()
+		s.mux.RUnlock()
+	}
+	return msg, err
+}
+
+// Send sends one text frame to session, with options (see WithPriority and WithTTL)
+func (s *session) Send(msg string, opts ...SendOption) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.sendMessageLocked(msg, false, newMessageMeta(opts))
+}
+
+// InitialRequest returns a snapshot of the http request that created the session
+func (s *session) InitialRequest() RequestInfo {
+	return s.initialReq
+}
+
+// RemoteAddr returns the IP address of the client that created the session.
+// If the session was created through a trusted proxy (see Options.TrustedProxies)
+// the address is resolved from forwarding headers.
+func (s *session) RemoteAddr() string {
+	return s.remoteAddr
+}
+
+// Stats returns traffic counters of the session
+func (s *session) Stats() SessionStats {
+	return SessionStats{
+		ReceiverAttachments: s.attachments.Load(),
+		MessagesIn:          s.messagesIn.Load(),
+		MessagesOut:         s.messagesOut.Load(),
+		BytesIn:             s.bytesIn.Load(),
+		BytesOut:            s.bytesOut.Load(),
+	}
+}
+
+// RTT returns the round-trip time measured by the last websocket ping (see Options.WebsocketPingInterval),
+// or zero if not measured.
+func (s *session) RTT() time.Duration {
+	return time.Duration(s.rtt.Load())
+}
+
+// CSRFToken returns the token the client has to present on send requests (see Options.CSRFTokens),
+// or an empty string if tokens are disabled.
+func (s *session) CSRFToken() string {
+	return s.csrfToken
 }
 
-// Send sends one text frame to session
-func (s *session) Send(msg string) error {
-	return s.sendMessage(msg)
+// Protocol returns the websocket subprotocol negotiated with the client (see Options.RawWebsocketProtocols),
+// or an empty string if none was negotiated or the session does not use websocket transport.
+func (s *session) Protocol() string {
+	return s.protocol
 }
 
-// Request returns the first http request
+// Request returns the latest http request, it changes with every receiver (i.e. xhr poll) attached to the session.
+// Use InitialRequest to inspect the request that created the session.
 func (s *session) Request() *http.Request {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -254,7 +683,8 @@ func (s *session) ReceiverType() ReceiverType {
 }
 
 // Context returns session context, the context is cancelled
-// whenever the session gets into closing or closed state
+// whenever the session gets into closing or closed state.
+// The cause of the cancellation (see context.Cause) is the same *CloseError returned by Err.
 func (s *session) Context() context.Context {
 	return s.context
 }
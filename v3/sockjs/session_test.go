@@ -1,7 +1,10 @@
 package sockjs
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
@@ -16,11 +19,11 @@ func newTestSession() *session {
 
 func TestSession_Create(t *testing.T) {
 	session := newTestSession()
-	_ = session.sendMessage("this is a message")
+	_ = session.Send("this is a message")
 	if len(session.sendBuffer) != 1 {
 		t.Errorf("session send buffer should contain 1 message")
 	}
-	_ = session.sendMessage("another message")
+	_ = session.Send("another message")
 	if len(session.sendBuffer) != 2 {
 		t.Errorf("session send buffer should contain 2 messages")
 	}
@@ -41,12 +44,42 @@ func TestSession_Request(t *testing.T) {
 	}
 }
 
+func TestSession_InitialRequest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	sess := newSession(req, "session", time.Second, time.Second)
+	req2, _ := http.NewRequest("POST", "/server/session/xhr_send", nil)
+	sess.setCurrentRequest(req2)
+
+	if info := sess.InitialRequest(); info.URL.Path != "/server/session/xhr" {
+		t.Errorf("Initial request should not change, got '%s'", info.URL.Path)
+	}
+	if sess.RemoteAddr() != "10.0.0.1" {
+		t.Errorf("Unexpected remote address '%s'", sess.RemoteAddr())
+	}
+}
+
+func TestSession_Stats(t *testing.T) {
+	session := newTestSession()
+	noError(t, session.Send("12345"))
+	go func() { _, _ = session.Recv() }()
+	noError(t, session.accept("123"))
+	recv := newTestReceiver()
+	noError(t, session.attachReceiver(recv))
+	noError(t, session.Send("1234567890"))
+
+	expected := SessionStats{ReceiverAttachments: 1, MessagesIn: 1, MessagesOut: 2, BytesIn: 3, BytesOut: 15}
+	if stats := session.Stats(); stats != expected {
+		t.Errorf("Unexpected session stats, got '%+v' expected '%+v'", stats, expected)
+	}
+}
+
 func TestSession_ConcurrentSend(t *testing.T) {
 	session := newTestSession()
 	done := make(chan bool)
 	for i := 0; i < 100; i++ {
 		go func() {
-			_ = session.sendMessage("message D")
+			_ = session.Send("message D")
 			done <- true
 		}()
 	}
@@ -139,8 +172,10 @@ func TestSession_AttachReceiverAndRefuse(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		go func() {
 			defer a.Done()
-			if err := session.attachReceiver(newTestReceiver()); err != errSessionReceiverAttached {
-				t.Errorf("Should return error as another receiver is already attached")
+			err := session.attachReceiver(newTestReceiver())
+			var closeErr *CloseError
+			if !errors.As(err, &closeErr) || closeErr.Cause != CloseCauseReceiverConflict {
+				t.Errorf("Should return receiver conflict error as another receiver is already attached, got '%v'", err)
 			}
 		}()
 	}
@@ -158,8 +193,8 @@ func TestSession_DetachReceiver(t *testing.T) {
 
 func TestSession_SendWithRecv(t *testing.T) {
 	session := newTestSession()
-	noError(t, session.sendMessage("message A"))
-	_ = session.sendMessage("message B")
+	noError(t, session.Send("message A"))
+	_ = session.Send("message B")
 	if len(session.sendBuffer) != 2 {
 		t.Errorf("There should be 2 messages in buffer, but there are %d", len(session.sendBuffer))
 	}
@@ -170,11 +205,11 @@ func TestSession_SendWithRecv(t *testing.T) {
 	if len(recv.frames[1:]) != 2 {
 		t.Errorf("Reciver should get 2 message frames from session, got %d", len(recv.frames))
 	}
-	noError(t, session.sendMessage("message C"))
+	noError(t, session.Send("message C"))
 	if len(recv.frames[1:]) != 3 {
 		t.Errorf("Reciver should get 3 message frames from session, got %d", len(recv.frames))
 	}
-	noError(t, session.sendMessage("message D"))
+	noError(t, session.Send("message D"))
 	if len(recv.frames[1:]) != 4 {
 		t.Errorf("Reciver should get 4 frames from session, got %d", len(recv.frames))
 	}
@@ -183,6 +218,36 @@ func TestSession_SendWithRecv(t *testing.T) {
 	}
 }
 
+func TestSession_SendCoalesced(t *testing.T) {
+	session := newTestSession()
+	session.sendCoalesceWindow = 100 * time.Millisecond
+	recv := newTestReceiver()
+	noError(t, session.attachReceiver(recv))
+
+	noError(t, session.Send("message A"))
+	noError(t, session.Send("message B"))
+	recv.waitFrames(t, 3)
+	recv.Lock()
+	if recv.frames[1] != "message A" || recv.frames[2] != "message B" {
+		t.Errorf("Coalesced messages should be flushed after window, got frames '%v'", recv.frames)
+	}
+	if len(recv.bulks) != 1 || len(recv.bulks[0]) != 2 {
+		t.Errorf("Coalesced messages should be written in one frame, got bulks '%v'", recv.bulks)
+	}
+	recv.Unlock()
+
+	noError(t, session.Send("message C"))
+	noError(t, session.Close(1, "closed"))
+	recv.Lock()
+	if len(recv.frames) != 5 || recv.frames[3] != "message C" || recv.frames[4] != "c[1,\"closed\"]" {
+		t.Errorf("Pending messages should be flushed before close frame, got frames '%v'", recv.frames)
+	}
+	if len(recv.bulks) != 2 {
+		t.Errorf("Pending message should be written in its own frame, got bulks '%v'", recv.bulks)
+	}
+	recv.Unlock()
+}
+
 func TestSession_Recv(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -219,7 +284,7 @@ func TestSession_Closing(t *testing.T) {
 	if _, err := session.Recv(); err == nil {
 		t.Errorf("session's receive buffer channel should close")
 	}
-	if err := session.sendMessage("some message"); err != ErrSessionNotOpen {
+	if err := session.Send("some message"); !errors.Is(err, ErrSessionNotOpen) {
 		t.Errorf("session should not accept new message after close")
 	}
 }
@@ -236,12 +301,12 @@ func TestSession_SessionRecv(t *testing.T) {
 	go func() {
 		s.closing()
 		_, err := s.Recv()
-		if err != ErrSessionNotOpen {
+		if !errors.Is(err, ErrSessionNotOpen) {
 			t.Errorf("session not in correct state, got '%v', expected '%v'", err, ErrSessionNotOpen)
 		}
 	}()
 	_, err = s.Recv()
-	if err != ErrSessionNotOpen {
+	if !errors.Is(err, ErrSessionNotOpen) {
 		t.Errorf("session not in correct state, got '%v', expected '%v'", err, ErrSessionNotOpen)
 	}
 }
@@ -290,11 +355,88 @@ func TestSession_SessionClose(t *testing.T) {
 			t.Errorf("Close frame not received by recv, frames '%v'", recv.frames)
 		}
 	}
-	if err := s.Close(1, "some other reson"); err != ErrSessionNotOpen {
+	if err := s.Close(1, "some other reson"); !errors.Is(err, ErrSessionNotOpen) {
 		t.Errorf("Expected error, got '%v'", err)
 	}
 }
 
+func TestSession_Err(t *testing.T) {
+	asCloseError := func(err error) *CloseError {
+		var closeErr *CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("Expected *CloseError, got '%v'", err)
+		}
+		return closeErr
+	}
+
+	s := newTestSession()
+	if s.Err() != nil {
+		t.Errorf("Open session should not have error, got '%v'", s.Err())
+	}
+	noError(t, s.Close(3000, "bye"))
+	if err := asCloseError(s.Err()); err.Code != 3000 || err.Reason != "bye" || err.Cause != CloseCauseServerClose {
+		t.Errorf("Unexpected close error '%+v'", err)
+	}
+	if _, err := s.Recv(); asCloseError(err) != s.Err() {
+		t.Errorf("Recv should return close error, got '%v'", err)
+	}
+	if err := s.Send("message"); asCloseError(err) != s.Err() {
+		t.Errorf("Send should return close error, got '%v'", err)
+	}
+	s.close()
+	if err := asCloseError(context.Cause(s.Context())); err.Cause != CloseCauseServerClose {
+		t.Errorf("Close error should be kept after session is closed, got '%+v'", err)
+	}
+
+	s = newSession(nil, "id", time.Millisecond, time.Second)
+	<-s.closeCh
+	if err := asCloseError(s.Err()); err.Cause != CloseCauseTimeout {
+		t.Errorf("Unexpected close cause, got '%v' expected '%v'", err.Cause, CloseCauseTimeout)
+	}
+
+	s = newTestSession()
+	recv := newTestReceiver()
+	noError(t, s.attachReceiver(recv))
+	go s.serveReceiver(recv)
+	close(recv.interruptCh)
+	<-s.closeCh
+	if err := asCloseError(s.Err()); err.Cause != CloseCauseClientDisconnect {
+		t.Errorf("Unexpected close cause, got '%v' expected '%v'", err.Cause, CloseCauseClientDisconnect)
+	}
+}
+
+// countingSession decorates a Session like applications do, it overrides only Send
+type countingSession struct {
+	Session
+	sent int
+}
+
+func (c *countingSession) Send(msg string, opts ...SendOption) error {
+	c.sent++
+	return c.Session.Send(msg, opts...)
+}
+
+func TestSession_Decorated(t *testing.T) {
+	s := newTestSession()
+	recv := newTestReceiver()
+	noError(t, s.attachReceiver(recv))
+	decorated := &countingSession{Session: s}
+
+	noError(t, decorated.Send("a"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	noError(t, decorated.SendCtx(ctx, "b"))
+	noError(t, decorated.Flush(ctx))
+	if decorated.sent != 1 || decorated.Stats().MessagesOut != 2 {
+		t.Errorf("Unexpected sends, decorator counted %d, session %d", decorated.sent, decorated.Stats().MessagesOut)
+	}
+	noError(t, decorated.Close(3000, "bye"))
+	var closeErr *CloseError
+	if !errors.As(decorated.Err(), &closeErr) || closeErr.Code != 3000 {
+		t.Errorf("Decorated session should report close error, got '%v'", decorated.Err())
+	}
+}
+
 func TestSession_SessionSessionId(t *testing.T) {
 	s := newTestSession()
 	if s.ID() != "sessionId" {
@@ -302,6 +444,77 @@ func TestSession_SessionSessionId(t *testing.T) {
 	}
 }
 
+func TestSession_ReceiverTakeover(t *testing.T) {
+	sess := newTestSession()
+	sess.conflictPolicy = ReceiverConflictTakeover
+	sess.asyncWriter = true
+	old := newBlockingReceiver(nil)
+	noError(t, sess.attachReceiver(old))
+	noError(t, sess.Send("message 1"))
+	noError(t, sess.Send("message 2"))
+
+	recv := newTestReceiver()
+	noError(t, sess.attachReceiver(recv))
+	close(old.release)
+	select {
+	case <-old.doneNotify():
+	case <-time.After(time.Second):
+		t.Fatalf("Old receiver should be closed")
+	}
+	noError(t, sess.Send("message 3"))
+	sess.close()
+	<-recv.doneNotify()
+
+	recv.Lock()
+	defer recv.Unlock()
+	expected := []string{"message 1", "message 2", "message 3"}
+	if len(recv.frames) < len(expected) {
+		t.Fatalf("Unexpected frames, got '%v' expected '%v'", recv.frames, expected)
+	}
+	for i, frame := range expected {
+		if recv.frames[i] != frame {
+			t.Errorf("Unexpected frame, got '%s' expected '%s'", recv.frames[i], frame)
+		}
+	}
+	old.Lock()
+	defer old.Unlock()
+	if len(old.bulks) != 0 || len(old.frames) == 0 || old.frames[len(old.frames)-1] != cFrame {
+		t.Errorf("Old receiver should be closed with close frame only, got '%v'", old.frames)
+	}
+	if stats := sess.Stats(); stats.MessagesOut != 3 || stats.ReceiverAttachments != 2 {
+		t.Errorf("Unexpected stats '%+v'", stats)
+	}
+}
+
+func TestSession_ReceiverTakeoverSync(t *testing.T) {
+	sess := newTestSession()
+	sess.conflictPolicy = ReceiverConflictTakeover
+	old := newTestReceiver()
+	noError(t, sess.attachReceiver(old))
+	noError(t, sess.Send("message 1"))
+
+	recv := newTestReceiver()
+	noError(t, sess.attachReceiver(recv))
+	noError(t, sess.Send("message 2"))
+	if old.canSend() {
+		t.Errorf("Old receiver should be closed")
+	}
+	old.Lock()
+	if expected := []string{"o", "message 1", cFrame}; !reflect.DeepEqual(old.frames, expected) {
+		t.Errorf("Unexpected frames of old receiver, got '%v' expected '%v'", old.frames, expected)
+	}
+	old.Unlock()
+	recv.Lock()
+	if expected := []string{"message 2"}; !reflect.DeepEqual(recv.frames, expected) {
+		t.Errorf("Unexpected frames of new receiver, got '%v' expected '%v'", recv.frames, expected)
+	}
+	recv.Unlock()
+	if state := sess.GetSessionState(); state != SessionActive {
+		t.Errorf("Session should stay active after takeover, got '%v'", state)
+	}
+	sess.close()
+}
+
 func newTestReceiver() *testReceiver {
 	return &testReceiver{
 		doneCh:      make(chan struct{}),
@@ -313,6 +526,7 @@ type testReceiver struct {
 	sync.Mutex
 	doneCh, interruptCh chan struct{}
 	frames              []string
+	bulks               [][]string // messages of every sendBulk call, i.e. of every "a" frame
 }
 
 func (t *testReceiver) doneNotify() <-chan struct{}        { return t.doneCh }
@@ -327,6 +541,9 @@ func (t *testReceiver) canSend() bool {
 	}
 }
 func (t *testReceiver) sendBulk(messages ...string) error {
+	t.Lock()
+	t.bulks = append(t.bulks, append([]string(nil), messages...))
+	t.Unlock()
 	for _, m := range messages {
 		if err := t.sendFrame(m); err != nil {
 			return err
@@ -341,6 +558,24 @@ func (t *testReceiver) sendFrame(frame string) error {
 	return nil
 }
 
+// waitFrames waits until the receiver got at least n frames
+func (t *testReceiver) waitFrames(tb testing.TB, n int) {
+	tb.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		t.Lock()
+		got := len(t.frames)
+		t.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("Expected %d frames, got %d", n, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func noError(t *testing.T, err error) {
 	if err != nil {
 		t.Error(err)
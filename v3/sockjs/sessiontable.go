@@ -0,0 +1,92 @@
+package sockjs
+
+import "sync"
+
+// sessionShards is the number of shards of the session table, a power of two
+const sessionShards = 64
+
+// sessionTable maps session IDs to sessions. It is sharded by session ID hash, so that creating and removing
+// sessions in one shard does not block other shards, and lookups do not take any lock. The zero value is ready to use.
+type sessionTable struct {
+	shards [sessionShards]sessionShard
+	// websocket sessions, they are never looked up by ID and their IDs may collide with other sessions
+	conns sync.Map // *session -> struct{}
+}
+
+type sessionShard struct {
+	mux      sync.Mutex // serializes creation and removal of sessions in the shard
+	sessions sync.Map   // string -> *session
+}
+
+func (t *sessionTable) shard(id string) *sessionShard {
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &t.shards[h&(sessionShards-1)]
+}
+
+// get looks the session up without locking
+func (t *sessionTable) get(id string) (*session, bool) {
+	sess, ok := t.shard(id).sessions.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return sess.(*session), true
+}
+
+// getOrCreate returns the session with the id, or stores a new one made by create. The create function
+// is called with the shard locked, so concurrent requests for the same id create only one session.
+func (t *sessionTable) getOrCreate(id string, create func() (*session, error)) (sess *session, created bool, err error) {
+	if sess, ok := t.get(id); ok {
+		return sess, false, nil
+	}
+	shard := t.shard(id)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	if sess, ok := shard.sessions.Load(id); ok {
+		return sess.(*session), false, nil
+	}
+	if sess, err = create(); err != nil {
+		return nil, false, err
+	}
+	shard.sessions.Store(id, sess)
+	return sess, true, nil
+}
+
+// addConn adds a websocket session
+func (t *sessionTable) addConn(sess *session) {
+	t.conns.Store(sess, struct{}{})
+}
+
+func (t *sessionTable) removeConn(sess *session) {
+	t.conns.Delete(sess)
+}
+
+// all returns all sessions of the table. Shards are locked one by one, so a session created
+// in a shard after it was visited is not returned.
+func (t *sessionTable) all() []*session {
+	var sessions []*session
+	collect := func(sess interface{}) bool {
+		sessions = append(sessions, sess.(*session))
+		return true
+	}
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mux.Lock()
+		shard.sessions.Range(func(_, sess interface{}) bool { return collect(sess) })
+		shard.mux.Unlock()
+	}
+	t.conns.Range(func(sess, _ interface{}) bool { return collect(sess) })
+	return sessions
+}
+
+// remove removes the session unless the id was already taken by another session
+func (t *sessionTable) remove(id string, sess *session) {
+	shard := t.shard(id)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	shard.sessions.CompareAndDelete(id, sess)
+}
@@ -0,0 +1,58 @@
+package sockjs
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionTable_GetOrCreate(t *testing.T) {
+	var table sessionTable
+	var creations atomic.Int32
+	var wg sync.WaitGroup
+	sessions := make([]*session, 50)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sess, _, err := table.getOrCreate("id", func() (*session, error) {
+				creations.Add(1)
+				return newTestSession(), nil
+			})
+			noError(t, err)
+			sessions[i] = sess
+		}(i)
+	}
+	wg.Wait()
+	if creations.Load() != 1 {
+		t.Errorf("Session should be created once, got %d", creations.Load())
+	}
+	for _, sess := range sessions {
+		if sess != sessions[0] {
+			t.Fatalf("All callers should get the same session")
+		}
+	}
+
+	createErr := errors.New("refused")
+	if _, created, err := table.getOrCreate("other", func() (*session, error) { return nil, createErr }); err != createErr || created {
+		t.Errorf("Unexpected result, got created %v error '%v'", created, err)
+	}
+	if _, ok := table.get("other"); ok {
+		t.Errorf("Refused session should not be stored")
+	}
+}
+
+func TestSessionTable_Remove(t *testing.T) {
+	var table sessionTable
+	old, current := newTestSession(), newTestSession()
+	_, _, _ = table.getOrCreate("id", func() (*session, error) { return current, nil })
+	table.remove("id", old)
+	if sess, ok := table.get("id"); !ok || sess != current {
+		t.Errorf("Removing replaced session should keep the current one")
+	}
+	table.remove("id", current)
+	if _, ok := table.get("id"); ok {
+		t.Errorf("Session should be removed")
+	}
+}
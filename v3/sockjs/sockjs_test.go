@@ -3,15 +3,12 @@ package sockjs
 import (
 	"net/http"
 	"net/http/httptest"
-	"regexp"
 	"testing"
 )
 
 func TestSockJS_ServeHTTP(t *testing.T) {
-	m := Handler{mappings: make([]*mapping, 0)}
-	m.mappings = []*mapping{
-		{"POST", regexp.MustCompile("/foo/.*"), []http.HandlerFunc{func(http.ResponseWriter, *http.Request) {}}},
-	}
+	m := Handler{router: newRouter()}
+	m.router.path("/foo/bar").add("POST", nil, func(http.ResponseWriter, *http.Request) {})
 	req, _ := http.NewRequest("GET", "/foo/bar", nil)
 	rec := httptest.NewRecorder()
 	m.ServeHTTP(rec, req)
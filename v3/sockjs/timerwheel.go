@@ -0,0 +1,163 @@
+package sockjs
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultWheelSlots is the number of slots of the timer wheel. Timers further than slots*tick ahead
+// wait in their slot for the wheel to turn around (rounds).
+const defaultWheelSlots = 1024
+
+// sessionTimer is a scheduled callback that can be cancelled, implemented by *time.Timer and *wheelTimer
+type sessionTimer interface {
+	Stop() bool
+}
+
+// scheduler creates session timers
+type scheduler interface {
+	AfterFunc(d time.Duration, f func()) sessionTimer
+}
+
+// stdScheduler schedules every callback with its own runtime timer
+type stdScheduler struct{}
+
+func (stdScheduler) AfterFunc(d time.Duration, f func()) sessionTimer { return time.AfterFunc(d, f) }
+
+// timerWheels spreads timers over independent wheels, so that scheduling from many goroutines
+// does not contend on a single wheel lock
+type timerWheels struct {
+	wheels []*timerWheel
+	next   atomic.Uint32
+}
+
+// newTimerWheels creates a wheel for every processor
+func newTimerWheels(tick time.Duration, slots int) *timerWheels {
+	w := &timerWheels{wheels: make([]*timerWheel, runtime.GOMAXPROCS(0))}
+	for i := range w.wheels {
+		w.wheels[i] = newTimerWheel(tick, slots)
+	}
+	return w
+}
+
+// AfterFunc schedules f on the next wheel in turn, the timer is stopped on the wheel it was scheduled on
+func (w *timerWheels) AfterFunc(d time.Duration, f func()) sessionTimer {
+	return w.wheels[int(w.next.Add(1)%uint32(len(w.wheels)))].AfterFunc(d, f)
+}
+
+// timerWheel is a hashed timing wheel shared by all sessions of a handler. Scheduling and stopping
+// a timer is O(1) and all timers are driven by a single ticker, which only runs while timers are pending.
+// Timers fire with tick resolution, up to one tick later but never earlier than requested.
+type timerWheel struct {
+	tick time.Duration
+
+	mux     sync.Mutex
+	slots   []wheelTimer // sentinels of circular lists of timers
+	cur     int
+	pending int
+	running bool
+}
+
+type wheelTimer struct {
+	wheel      *timerWheel
+	f          func()
+	rounds     int
+	prev, next *wheelTimer
+}
+
+func newTimerWheel(tick time.Duration, slots int) *timerWheel {
+	w := &timerWheel{tick: tick, slots: make([]wheelTimer, slots)}
+	for i := range w.slots {
+		w.slots[i].prev = &w.slots[i]
+		w.slots[i].next = &w.slots[i]
+	}
+	return w
+}
+
+// AfterFunc calls f once d elapses (rounded up to the wheel tick). Timers expiring in the same tick
+// are called one after another in a goroutine of their own.
+func (w *timerWheel) AfterFunc(d time.Duration, f func()) sessionTimer {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t := &wheelTimer{wheel: w, f: f}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.running {
+		ticks++ // part of the current tick has passed already
+	}
+	t.rounds = (ticks - 1) / len(w.slots)
+	head := &w.slots[(w.cur+ticks)%len(w.slots)]
+	t.prev, t.next = head.prev, head
+	head.prev.next = t
+	head.prev = t
+	w.pending++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	return t
+}
+
+// Stop prevents the timer from firing. It returns false if the timer already fired or was stopped.
+func (t *wheelTimer) Stop() bool {
+	t.wheel.mux.Lock()
+	defer t.wheel.mux.Unlock()
+	if t.next == nil {
+		return false
+	}
+	t.wheel.unlink(t)
+	return true
+}
+
+func (w *timerWheel) unlink(t *wheelTimer) {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+	w.pending--
+}
+
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !w.advance() {
+			return
+		}
+	}
+}
+
+// advance moves the wheel by one tick and fires expired timers. It returns false and stops
+// the wheel once there are no pending timers.
+func (w *timerWheel) advance() bool {
+	var expired []func()
+	w.mux.Lock()
+	w.cur = (w.cur + 1) % len(w.slots)
+	head := &w.slots[w.cur]
+	for t := head.next; t != head; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			w.unlink(t)
+			expired = append(expired, t.f)
+		}
+		t = next
+	}
+	running := w.pending > 0
+	w.running = running
+	w.mux.Unlock()
+
+	if len(expired) > 0 {
+		go func() {
+			for _, f := range expired {
+				f()
+			}
+		}()
+	}
+	return running
+}
@@ -0,0 +1,165 @@
+package sockjs
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel_Fires(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 4)
+	fired := make(chan time.Duration, 1)
+	start := time.Now()
+	// longer than the wheel, the timer has to wait for several rounds
+	w.AfterFunc(15*time.Millisecond, func() { fired <- time.Since(start) })
+	select {
+	case elapsed := <-fired:
+		if elapsed < 15*time.Millisecond {
+			t.Errorf("Timer fired too early, after %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timer should fire")
+	}
+}
+
+func TestTimerWheel_NotEarlierWhileRunning(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := newTimerWheel(tick, 8)
+	keepRunning := w.AfterFunc(time.Second, func() {})
+	defer keepRunning.Stop()
+	for _, offset := range []time.Duration{2 * time.Millisecond, 5 * time.Millisecond, 8 * time.Millisecond} {
+		time.Sleep(offset) // schedule in the middle of a tick
+		fired := make(chan time.Duration, 1)
+		start := time.Now()
+		w.AfterFunc(tick, func() { fired <- time.Since(start) })
+		select {
+		case elapsed := <-fired:
+			if elapsed < tick {
+				t.Errorf("Timer fired too early, after %v", elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timer should fire")
+		}
+	}
+}
+
+func TestTimerWheel_Stop(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 8)
+	var fired atomic.Bool
+	timer := w.AfterFunc(5*time.Millisecond, func() { fired.Store(true) })
+	if !timer.Stop() {
+		t.Errorf("Pending timer should be stopped")
+	}
+	if timer.Stop() {
+		t.Errorf("Stopped timer should not be stopped again")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if fired.Load() {
+		t.Errorf("Stopped timer should not fire")
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.pending != 0 || w.running {
+		t.Errorf("Wheel should stop without pending timers, pending %d running %v", w.pending, w.running)
+	}
+}
+
+func TestTimerWheel_Order(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 16)
+	fired := make(chan int, 3)
+	for _, d := range []int{30, 10, 20} {
+		d := d
+		w.AfterFunc(time.Duration(d)*time.Millisecond, func() { fired <- d })
+	}
+	for _, expected := range []int{10, 20, 30} {
+		if got := <-fired; got != expected {
+			t.Errorf("Unexpected timer fired, got %d expected %d", got, expected)
+		}
+	}
+}
+
+func TestTimerWheels_Spread(t *testing.T) {
+	w := newTimerWheels(time.Millisecond, 16)
+	fired := make(chan struct{}, len(w.wheels))
+	var timers []sessionTimer
+	for range w.wheels {
+		timers = append(timers, w.AfterFunc(time.Hour, func() {}))
+		w.AfterFunc(time.Millisecond, func() { fired <- struct{}{} })
+	}
+	for _, wheel := range w.wheels {
+		wheel.mux.Lock()
+		pending := wheel.pending
+		wheel.mux.Unlock()
+		if pending == 0 {
+			t.Errorf("Timers should be spread over all wheels")
+		}
+	}
+	for range w.wheels {
+		select {
+		case <-fired:
+		case <-time.After(time.Second):
+			t.Fatal("Timer did not fire")
+		}
+	}
+	for _, timer := range timers {
+		if !timer.Stop() {
+			t.Errorf("Timer should be stopped on its wheel")
+		}
+	}
+}
+
+func TestSession_LimitTimersOnTimerWheel(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 64)
+	sess := newSession(nil, "id", time.Hour, time.Hour)
+	sess.useScheduler(w)
+	sess.startIdleTimer(5 * time.Millisecond)
+	noError(t, sess.ExtendLifetime(time.Hour))
+	w.mux.Lock()
+	pending := w.pending
+	w.mux.Unlock()
+	if pending != 3 { // disconnect, idle and lifetime timers
+		t.Errorf("Session timers should be scheduled on the wheel, got %d pending", pending)
+	}
+	select {
+	case <-sess.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Idle session should be closed by the wheel")
+	}
+	var closeErr *CloseError
+	if !errors.As(sess.Err(), &closeErr) || closeErr.Cause != CloseCauseIdleTimeout {
+		t.Errorf("Unexpected close error '%v'", sess.Err())
+	}
+}
+
+func TestSession_TimerWheelHeartbeat(t *testing.T) {
+	sess := newSession(nil, "id", time.Hour, 5*time.Millisecond)
+	sess.heartbeatJitter = 2 * time.Millisecond
+	sess.useScheduler(newTimerWheel(time.Millisecond, 64))
+	recv := newTestReceiver()
+	noError(t, sess.attachReceiver(recv))
+	time.Sleep(30 * time.Millisecond)
+	sess.close()
+	recv.Lock()
+	defer recv.Unlock()
+	heartbeats := 0
+	for _, frame := range recv.frames {
+		if frame == "h" {
+			heartbeats++
+		}
+	}
+	if heartbeats < 2 {
+		t.Errorf("Heartbeats should be sent by the timer wheel, got frames '%v'", recv.frames)
+	}
+}
+
+func TestSession_HeartbeatJitter(t *testing.T) {
+	sess := newTestSession()
+	sess.heartbeatInterval = 10 * time.Second
+	sess.heartbeatJitter = time.Second
+	for i := 0; i < 100; i++ {
+		if d := sess.nextHeartbeat(); d > 10*time.Second || d <= 9*time.Second {
+			t.Fatalf("Heartbeat delay out of jitter range, got %v", d)
+		}
+	}
+}
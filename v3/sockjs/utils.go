@@ -1,16 +1,112 @@
 package sockjs
 
-import "encoding/json"
+import (
+	"net"
+	"net/http"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
 
 func quote(in string) string {
-	quoted, _ := json.Marshal(in)
-	return string(quoted)
+	return string(appendQuote(nil, in))
+}
+
+// appendQuote appends JSON encoded string to dst with HTML characters escaped, see appendJSONString.
+func appendQuote(dst []byte, s string) []byte {
+	return appendJSONString(dst, s, true)
+}
+
+// appendJSONString appends JSON encoded string to dst without intermediate allocations. Besides characters
+// required by JSON, it escapes the same characters as sockjs-node does: control characters, invisible and
+// line separating characters that some browsers mangle or treat as line terminators, and U+FFF0-U+FFFF.
+// Invalid UTF-8 is replaced by escaped U+FFFD, except encoded lone surrogates, which are escaped as they are.
+// If escapeHTML is set, <, > and & are escaped as well, like json.Marshal does.
+func appendJSONString(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != 0x7f && b != '"' && b != '\\' && (!escapeHTML || (b != '<' && b != '>' && b != '&')) {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = appendUnicodeEscape(dst, rune(b))
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			if surrogate, ok := decodeSurrogate(s[i:]); ok {
+				dst = appendUnicodeEscape(dst, surrogate)
+				size = 3
+			} else {
+				dst = appendUnicodeEscape(dst, utf8.RuneError)
+			}
+			i += size
+			start = i
+			continue
+		}
+		if escapableRune(c) {
+			dst = append(dst, s[start:i]...)
+			dst = appendUnicodeEscape(dst, c)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// escapableRune reports whether non-ASCII rune is escaped by sockjs-node
+func escapableRune(r rune) bool {
+	switch {
+	case r <= 0x9f, // C1 control characters
+		r == 0xad, r >= 0x600 && r <= 0x604, r == 0x70f, r == 0x17b4, r == 0x17b5,
+		r >= 0x200c && r <= 0x200f, r >= 0x2028 && r <= 0x202f, r >= 0x2060 && r <= 0x206f,
+		r == 0xfeff, r >= 0xfff0 && r <= 0xffff:
+		return true
+	}
+	return false
+}
+
+// decodeSurrogate decodes UTF-16 surrogate encoded as 3 byte UTF-8 sequence (invalid in UTF-8, but produced
+// by some encoders for lone surrogates)
+func decodeSurrogate(s string) (rune, bool) {
+	if len(s) < 3 || s[0] != 0xed || s[1] < 0xa0 || s[1] > 0xbf || s[2] < 0x80 || s[2] > 0xbf {
+		return 0, false
+	}
+	return 0xd000 | rune(s[1]&0x3f)<<6 | rune(s[2]&0x3f), true
+}
+
+func appendUnicodeEscape(dst []byte, r rune) []byte {
+	return append(dst, '\\', 'u', hex[r>>12&0xf], hex[r>>8&0xf], hex[r>>4&0xf], hex[r&0xf])
 }
 
-func transform(values []string, transformFn func(string) string) []string {
-	ret := make([]string, len(values))
-	for i, msg := range values {
-		ret[i] = transformFn(msg)
+// clientIP returns address of the client that sent the request, without port
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
 	}
-	return ret
+	return req.RemoteAddr
 }
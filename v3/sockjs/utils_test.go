@@ -1,6 +1,9 @@
 package sockjs
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 func TestQuote(t *testing.T) {
 	var quotationTests = []struct {
@@ -17,3 +20,43 @@ func TestQuote(t *testing.T) {
 		}
 	}
 }
+
+func TestQuote_SockJSNodeEscaping(t *testing.T) {
+	var quotationTests = []struct {
+		input  string
+		output string
+	}{
+		{"", `""`},
+		{"tab\tnew line\ncarriage\rbackslash\\", `"tab\tnew line\ncarriage\rbackslash\\"`},
+		{"\x00\x01\x1f\x7f", `"\u0000\u0001\u001f\u007f"`},
+		{"<script>&</script>", `"\u003cscript\u003e\u0026\u003c/script\u003e"`},
+		{"unicode: \u017elu\u0165, \u65e5\u672c, \U0001f600", "\"unicode: \u017elu\u0165, \u65e5\u672c, \U0001f600\""},
+		{"c1: \u0080\u009f", `"c1: \u0080\u009f"`},
+		{"invisible: \u00ad\u0600\u070f\u17b4\u200c\u2060\ufeff", `"invisible: \u00ad\u0600\u070f\u17b4\u200c\u2060\ufeff"`},
+		{"separators: \u2028\u2029\u202f", `"separators: \u2028\u2029\u202f"`},
+		{"specials: \ufff0\uffff", `"specials: \ufff0\uffff"`},
+		{"invalid utf8: \xff", `"invalid utf8: \ufffd"`},
+		{"lone surrogates: \xed\xa0\x80 \xed\xbf\xbf", `"lone surrogates: \ud800 \udfff"`},
+	}
+	for _, testCase := range quotationTests {
+		if got := quote(testCase.input); got != testCase.output {
+			t.Errorf("Unexpected quotation of %q, got '%s' expected '%s'", testCase.input, got, testCase.output)
+		}
+	}
+}
+
+func TestQuote_ValidJSON(t *testing.T) {
+	inputs := []string{"plain", "<&>", "\x00\x7f\u0085\u2028\ufeff\ufff9", "\u017elu\u0165 \u65e5\u672c \U0001f600"}
+	for _, in := range inputs {
+		var out string
+		if err := json.Unmarshal([]byte(quote(in)), &out); err != nil || out != in {
+			t.Errorf("Quotation of %q should decode to the same string, got %q (%v)", in, out, err)
+		}
+	}
+}
+
+func TestAppendJSONString_NoHTMLEscape(t *testing.T) {
+	if got := string(appendJSONString(nil, "<a href=\"x\">&</a>", false)); got != `"<a href=\"x\">&</a>"` {
+		t.Errorf("HTML characters should not be escaped, got '%s'", got)
+	}
+}
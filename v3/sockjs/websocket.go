@@ -1,15 +1,20 @@
 package sockjs
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func (h *Handler) sockjsWebsocket(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) sockjsWebsocket(rw http.ResponseWriter, req *http.Request, sessionID string) {
+	release, err := h.admit(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
 	upgrader := h.options.WebsocketUpgrader
 	if upgrader == nil {
 		upgrader = new(websocket.Upgrader)
@@ -18,47 +23,56 @@ func (h *Handler) sockjsWebsocket(rw http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		return
 	}
-	sessID, _ := h.parseSessionID(req.URL)
-	sess := newSession(req, sessID, h.options.DisconnectDelay, h.options.HeartbeatDelay)
+	sess := h.createSession(req, sessionID)
+	h.trackConn(sess)
+	defer h.sessions.removeConn(sess)
+	sess.protocol = conn.Subprotocol()
 	receiver := newWsReceiver(conn, h.options.WebsocketWriteTimeout)
+	receiver.escapeHTML = !h.options.DisableWebsocketHTMLEscape
 	if err := sess.attachReceiver(receiver); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if h.handlerFunc != nil {
-		go h.handlerFunc(Session{sess})
-	}
-	readCloseCh := make(chan struct{})
-	go func() {
-		var d []string
-		for {
-			err := conn.ReadJSON(&d)
-			if err != nil {
-				close(readCloseCh)
-				return
-			}
-			if err := sess.accept(d...); err != nil {
-				close(readCloseCh)
-				return
+	h.startHandler(sess)
+	pinger := h.startWsPinger(conn, sess)
+	defer pinger.stop()
+	// read in the goroutine serving the request, closing the receiver closes the connection and ends the loop
+	var d []string
+	for {
+		err := conn.ReadJSON(&d)
+		if err != nil {
+			if receiver.canSend() { // not closed by the server
+				closeOnReadError(sess, err)
 			}
+			break
+		}
+		if err := sess.accept(d...); err != nil {
+			break
 		}
-	}()
-
-	select {
-	case <-readCloseCh:
-	case <-receiver.doneNotify():
 	}
-	sess.close()
-	if err := conn.Close(); err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
+	sess.closeWith(&CloseError{Cause: CloseCauseClientDisconnect})
+	_ = conn.Close()
+}
+
+// closeOnReadError closes the session after reading from client's websocket connection failed with err.
+// Close frame received from the client is recorded in the session and reported in session's CloseError.
+func closeOnReadError(sess *session, err error) {
+	closeErr := &CloseError{Cause: CloseCauseClientDisconnect, Err: err}
+	clientClose := ClientClose{Code: websocket.CloseAbnormalClosure}
+	var wsErr *websocket.CloseError
+	if errors.As(err, &wsErr) && wsErr.Code != websocket.CloseAbnormalClosure {
+		clientClose = ClientClose{Code: wsErr.Code, Reason: wsErr.Text, Clean: true}
+		closeErr.Code, closeErr.Reason = uint32(wsErr.Code), wsErr.Text
 	}
+	sess.setClientClose(clientClose)
+	sess.closeWith(closeErr)
 }
 
 type wsReceiver struct {
 	conn         *websocket.Conn
 	closeCh      chan struct{}
 	writeTimeout time.Duration
+	escapeHTML   bool
 }
 
 func newWsReceiver(conn *websocket.Conn, writeTimeout time.Duration) *wsReceiver {
@@ -71,30 +85,40 @@ func newWsReceiver(conn *websocket.Conn, writeTimeout time.Duration) *wsReceiver
 
 func (w *wsReceiver) sendBulk(messages ...string) error {
 	if len(messages) > 0 {
-		return w.sendFrame(fmt.Sprintf("a[%s]", strings.Join(transform(messages, quote), ",")))
+		fb := getFrameBuffer()
+		fb.b = appendBulkFrame(fb.b, messages, w.escapeHTML)
+		err := w.writeFrame(fb.b)
+		putFrameBuffer(fb)
+		return err
 	}
 	return nil
 }
 
 func (w *wsReceiver) sendFrame(frame string) error {
+	return w.writeFrame([]byte(frame))
+}
+
+func (w *wsReceiver) writeFrame(frame []byte) error {
 	if w.writeTimeout != 0 {
 		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
 			w.close()
 			return err
 		}
 	}
-	if err := w.conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+	if err := w.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
 		w.close()
 		return err
 	}
 	return nil
 }
 
+// close closes the connection, which ends the read loop of the websocket handler (idempotent)
 func (w *wsReceiver) close() {
 	select {
 	case <-w.closeCh: // already closed
 	default:
 		close(w.closeCh)
+		_ = w.conn.Close()
 	}
 }
 func (w *wsReceiver) canSend() bool {
@@ -1,6 +1,7 @@
 package sockjs
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,7 +13,7 @@ import (
 
 func TestHandler_WebSocketHandshakeError(t *testing.T) {
 	h := newTestHandler()
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	defer server.Close()
 	req, _ := http.NewRequest("GET", server.URL, nil)
 	req.Header.Set("origin", "https"+server.URL[4:])
@@ -32,7 +33,7 @@ func TestHandler_WebSocketHandshakeError(t *testing.T) {
 
 func TestHandler_WebSocket(t *testing.T) {
 	h := newTestHandler()
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	defer server.CloseClientConnections()
 	url := "ws" + server.URL[4:]
 	var connCh = make(chan Session)
@@ -67,7 +68,7 @@ func TestHandler_WebSocket(t *testing.T) {
 
 func TestHandler_WebSocketTerminationByServer(t *testing.T) {
 	h := newTestHandler()
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	defer server.Close()
 	url := "ws" + server.URL[4:]
 	h.handlerFunc = func(conn Session) {
@@ -102,12 +103,12 @@ func TestHandler_WebSocketTerminationByServer(t *testing.T) {
 
 func TestHandler_WebSocketTerminationByClient(t *testing.T) {
 	h := newTestHandler()
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	defer server.Close()
 	url := "ws" + server.URL[4:]
 	var done = make(chan struct{})
 	h.handlerFunc = func(conn Session) {
-		if _, err := conn.Recv(); err != ErrSessionNotOpen {
+		if _, err := conn.Recv(); !errors.Is(err, ErrSessionNotOpen) {
 			t.Errorf("Recv should fail")
 		}
 		select {
@@ -129,7 +130,7 @@ func TestHandler_WebSocketTerminationByClient(t *testing.T) {
 func TestHandler_WebSocketCommunication(t *testing.T) {
 	h := newTestHandler()
 	h.options.WebsocketWriteTimeout = time.Second
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	// defer server.CloseClientConnections()
 	url := "ws" + server.URL[4:]
 	var done = make(chan struct{})
@@ -164,7 +165,7 @@ func TestHandler_CustomWebSocketCommunication(t *testing.T) {
 		Error:           func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
 	}
 	h.options.WebsocketWriteTimeout = time.Second
-	server := httptest.NewServer(http.HandlerFunc(h.sockjsWebsocket))
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
 	url := "ws" + server.URL[4:]
 	var done = make(chan struct{})
 	h.handlerFunc = func(conn Session) {
@@ -188,3 +189,173 @@ func TestHandler_CustomWebSocketCommunication(t *testing.T) {
 	}
 	<-done
 }
+
+func TestHandler_WebSocketAsyncWriter(t *testing.T) {
+	h := newTestHandler()
+	h.options.AsyncWriter = true
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+	var done = make(chan struct{})
+	h.handlerFunc = func(conn Session) {
+		noError(t, conn.Send("message 1"))
+		noError(t, conn.Send("message 2"))
+		noError(t, conn.Close(123, "close"))
+		close(done)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, map[string][]string{"Origin": []string{server.URL}})
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	var frames []string
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		frames = append(frames, string(msg))
+	}
+	<-done
+	// messages sent before the writer picks them up may be merged into one frame
+	if len(frames) < 3 || frames[0] != "o" || frames[len(frames)-1] != `c[123,"close"]` {
+		t.Errorf("Unexpected frames received '%v'", frames)
+	}
+}
+
+func TestHandler_WebSocketClientClose(t *testing.T) {
+	h := newTestHandler()
+	// the client closes the session, not the handler function returning
+	h.options.KeepSessionOnHandlerReturn = true
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+	sessions := make(chan Session, 1)
+	h.handlerFunc = func(s Session) {
+		if _, ok := s.ClientClose(); ok {
+			t.Errorf("Client close should not be known before the client closes")
+		}
+		sessions <- s
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	sess := (<-sessions).(*session)
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "logout"))
+	<-sess.Context().Done()
+	expected := ClientClose{Code: 4001, Reason: "logout", Clean: true}
+	if clientClose, ok := sess.ClientClose(); !ok || clientClose != expected {
+		t.Errorf("Unexpected client close, got '%+v' expected '%+v'", clientClose, expected)
+	}
+}
+
+func TestHandler_WebSocketPing(t *testing.T) {
+	h := newTestHandler()
+	// the session must stay open while the client answers pings
+	h.options.KeepSessionOnHandlerReturn = true
+	h.options.WebsocketPingInterval = 10 * time.Millisecond
+	h.options.WebsocketPongTimeout = time.Second // generous, the test must not depend on scheduling delays
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+	sessions := make(chan Session, 1)
+	h.handlerFunc = func(s Session) { sessions <- s }
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	pings := make(chan struct{}, 16)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		// reading makes the client answer pings with pongs
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	sess := (<-sessions).(*session)
+	// a ping is sent only once the previous one was answered, so several pings mean several pongs were accepted
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatalf("Client should receive ping %d", i+1)
+		}
+	}
+	if sess.RTT() <= 0 {
+		t.Errorf("RTT should be measured after pong")
+	}
+	if sess.GetSessionState() != SessionActive {
+		t.Errorf("Session should stay active while client answers pings, got '%v'", sess.GetSessionState())
+	}
+}
+
+func TestHandler_WebSocketPongTimeout(t *testing.T) {
+	h := newTestHandler()
+	// the session must be closed by the pong timeout
+	h.options.KeepSessionOnHandlerReturn = true
+	h.options.WebsocketPingInterval = 10 * time.Millisecond
+	h.options.WebsocketPongTimeout = 10 * time.Millisecond
+	server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+	defer server.Close()
+	sessions := make(chan Session, 1)
+	h.handlerFunc = func(s Session) { sessions <- s }
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(string) error { return nil }) // dead peer never answers
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	sess := (<-sessions).(*session)
+	select {
+	case <-sess.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Session should be closed when pong is missing")
+	}
+	var closeErr *CloseError
+	if err := sess.Err(); !errors.As(err, &closeErr) || closeErr.Cause != CloseCauseClientDisconnect {
+		t.Errorf("Unexpected session error, got '%v'", err)
+	}
+	if sess.RTT() != 0 {
+		t.Errorf("RTT should not be measured without pong, got '%v'", sess.RTT())
+	}
+}
+
+func TestHandler_WebSocketHTMLEscape(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		h := newTestHandler()
+		h.options.DisableWebsocketHTMLEscape = disable
+		h.handlerFunc = func(s Session) { _ = s.Send("<b>&</b>") }
+		server := httptest.NewServer(withSessionID(h.sockjsWebsocket))
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+		if err != nil {
+			t.Fatalf("websocket dial failed: %v", err)
+		}
+		expected := `a["\u003cb\u003e\u0026\u003c/b\u003e"]`
+		if disable {
+			expected = `a["<b>&</b>"]`
+		}
+		for _, frame := range []string{"o", expected} {
+			_, msg, err := conn.ReadMessage()
+			if err != nil || string(msg) != frame {
+				t.Errorf("Unexpected frame, got '%s' (%v) expected '%s'", msg, err, frame)
+			}
+		}
+		conn.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package sockjs
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPinger sends protocol level pings over websocket connection and requires a pong within the timeout.
+// A missing pong makes the pending read fail on read deadline, which closes the session.
+type wsPinger struct {
+	conn     *websocket.Conn
+	sess     *session
+	interval time.Duration
+	timeout  time.Duration
+
+	mux     sync.Mutex
+	timer   sessionTimer
+	payload string // payload of the ping waiting for pong, empty if none
+	sentAt  time.Time
+	stopped bool
+}
+
+// startWsPinger starts pinging the connection if Options.WebsocketPingInterval is set, returns nil otherwise.
+// It must be called before the connection is read from.
+func (h *Handler) startWsPinger(conn *websocket.Conn, sess *session) *wsPinger {
+	if h.options.WebsocketPingInterval <= 0 {
+		return nil
+	}
+	p := &wsPinger{
+		conn:     conn,
+		sess:     sess,
+		interval: h.options.WebsocketPingInterval,
+		timeout:  h.options.WebsocketPongTimeout,
+	}
+	if p.timeout <= 0 {
+		p.timeout = p.interval
+	}
+	conn.SetPongHandler(p.pong)
+	p.mux.Lock()
+	p.timer = sess.scheduler.AfterFunc(p.interval, p.ping)
+	p.mux.Unlock()
+	return p
+}
+
+func (p *wsPinger) ping() {
+	p.mux.Lock()
+	if p.stopped {
+		p.mux.Unlock()
+		return
+	}
+	p.sentAt = time.Now()
+	p.payload = strconv.FormatInt(p.sentAt.UnixNano(), 36)
+	payload, deadline := p.payload, p.sentAt.Add(p.timeout)
+	p.mux.Unlock()
+
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return
+	}
+	// failed ping write surfaces as read error once the read deadline passes
+	_ = p.conn.WriteControl(websocket.PingMessage, []byte(payload), deadline)
+}
+
+// pong is called from connection's read loop
+func (p *wsPinger) pong(appData string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.stopped || p.payload == "" || appData != p.payload {
+		return nil // unsolicited pong or pong to heartbeat ping
+	}
+	p.sess.rtt.Store(int64(time.Since(p.sentAt)))
+	p.payload = ""
+	if err := p.conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	p.timer = p.sess.scheduler.AfterFunc(p.interval, p.ping)
+	return nil
+}
+
+func (p *wsPinger) stop() {
+	if p == nil {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.stopped = true
+	p.timer.Stop()
+}
@@ -9,11 +9,11 @@ import (
 )
 
 var (
-	cFrame              = closeFrame(2010, "Another connection still open")
+	cFrame              = closeFrame(errSessionReceiverAttached.Code, errSessionReceiverAttached.Reason)
 	xhrStreamingPrelude = strings.Repeat("h", 2048)
 )
 
-func (h *Handler) xhrSend(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) xhrSend(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	if req.Body == nil {
 		httpError(rw, "Payload expected.", http.StatusBadRequest)
 		return
@@ -28,19 +28,15 @@ func (h *Handler) xhrSend(rw http.ResponseWriter, req *http.Request) {
 		httpError(rw, "Broken JSON encoding.", http.StatusBadRequest)
 		return
 	}
-	sessionID, err := h.parseSessionID(req.URL)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	h.sessionsMux.Lock()
-	sess, ok := h.sessions[sessionID]
-	h.sessionsMux.Unlock()
+	sess, ok := h.sessions.get(sessionID)
 	if !ok {
 		http.NotFound(rw, req)
 		return
 	}
+	if !checkCSRFToken(sess, req) {
+		http.Error(rw, "Invalid CSRF token.", http.StatusForbidden)
+		return
+	}
 	if err := sess.accept(messages...); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
@@ -51,15 +47,19 @@ func (h *Handler) xhrSend(rw http.ResponseWriter, req *http.Request) {
 
 type xhrFrameWriter struct{}
 
-func (*xhrFrameWriter) write(w io.Writer, frame string) (int, error) {
-	return fmt.Fprintf(w, "%s\n", frame)
+func (*xhrFrameWriter) write(w io.Writer, frame []byte) (int, error) {
+	fb := getFrameBuffer()
+	defer putFrameBuffer(fb)
+	fb.b = append(fb.b, frame...)
+	fb.b = append(fb.b, '\n')
+	return w.Write(fb.b)
 }
 
-func (h *Handler) xhrPoll(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) xhrPoll(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	rw.Header().Set("content-type", "application/javascript; charset=UTF-8")
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, sessionID)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		sessionError(rw, new(xhrFrameWriter), err)
 		return
 	}
 	receiver := newHTTPReceiver(rw, req, 1, new(xhrFrameWriter), ReceiverTypeXHR)
@@ -72,26 +72,19 @@ func (h *Handler) xhrPoll(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sess.startHandlerOnce.Do(func() {
-		if h.handlerFunc != nil {
-			go h.handlerFunc(Session{sess})
-		}
-	})
+	h.startHandler(sess)
 
-	select {
-	case <-receiver.doneNotify():
-	case <-receiver.interruptedNotify():
-	}
+	sess.serveReceiver(receiver)
 }
 
-func (h *Handler) xhrStreaming(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) xhrStreaming(rw http.ResponseWriter, req *http.Request, sessionID string) {
 	rw.Header().Set("content-type", "application/javascript; charset=UTF-8")
 	fmt.Fprintf(rw, "%s\n", xhrStreamingPrelude)
 	rw.(http.Flusher).Flush()
 
-	sess, err := h.sessionByRequest(req)
+	sess, err := h.sessionByRequest(req, sessionID)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		sessionError(rw, new(xhrFrameWriter), err)
 		return
 	}
 	receiver := newHTTPReceiver(rw, req, h.options.ResponseLimit, new(xhrFrameWriter), ReceiverTypeXHRStreaming)
@@ -104,10 +97,7 @@ func (h *Handler) xhrStreaming(rw http.ResponseWriter, req *http.Request) {
 		receiver.close()
 		return
 	}
-	sess.startHandlerOnce.Do(func() { go h.handlerFunc(Session{sess}) })
+	h.startHandler(sess)
 
-	select {
-	case <-receiver.doneNotify():
-	case <-receiver.interruptedNotify():
-	}
+	sess.serveReceiver(receiver)
 }
@@ -13,7 +13,7 @@ func TestHandler_XhrSendNilBody(t *testing.T) {
 	h := newTestHandler()
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/non_existing_session/xhr_send", nil)
-	h.xhrSend(rec, req)
+	withSessionID(h.xhrSend)(rec, req)
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response status, got '%d' expected '%d'", rec.Code, http.StatusBadRequest)
 	}
@@ -26,7 +26,7 @@ func TestHandler_XhrSendEmptyBody(t *testing.T) {
 	h := newTestHandler()
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/non_existing_session/xhr_send", strings.NewReader(""))
-	h.xhrSend(rec, req)
+	withSessionID(h.xhrSend)(rec, req)
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("Unexpected response status, got '%d' expected '%d'", rec.Code, http.StatusBadRequest)
 	}
@@ -36,12 +36,12 @@ func TestHandler_XhrSendEmptyBody(t *testing.T) {
 }
 
 func TestHandler_XhrSendWrongUrlPath(t *testing.T) {
-	h := newTestHandler()
+	h := NewHandler("", testOptions, nil)
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "incorrect", strings.NewReader("[\"a\"]"))
-	h.xhrSend(rec, req)
-	if rec.Code != http.StatusBadRequest {
-		t.Errorf("Unexcpected response status, got '%d', expected '%d'", rec.Code, http.StatusBadRequest)
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Unexcpected response status, got '%d', expected '%d'", rec.Code, http.StatusNotFound)
 	}
 }
 
@@ -50,12 +50,12 @@ func TestHandler_XhrSendToExistingSession(t *testing.T) {
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/xhr_send", strings.NewReader("[\"some message\"]"))
 	sess := newSession(req, "session", time.Second, time.Second)
-	h.sessions["session"] = sess
+	storeTestSession(h, "session", sess)
 
 	req, _ = http.NewRequest("POST", "/server/session/xhr_send", strings.NewReader("[\"some message\"]"))
 	var done = make(chan bool)
 	go func() {
-		h.xhrSend(rec, req)
+		withSessionID(h.xhrSend)(rec, req)
 		done <- true
 	}()
 	msg, _ := sess.Recv()
@@ -75,7 +75,7 @@ func TestHandler_XhrSendInvalidInput(t *testing.T) {
 	h := newTestHandler()
 	req, _ := http.NewRequest("POST", "/server/session/xhr_send", strings.NewReader("some invalid message frame"))
 	rec := httptest.NewRecorder()
-	h.xhrSend(rec, req)
+	withSessionID(h.xhrSend)(rec, req)
 	if rec.Code != http.StatusBadRequest || rec.Body.String() != "Broken JSON encoding." {
 		t.Errorf("Unexpected response, got '%d,%s' expected '%d,Broken JSON encoding.'", rec.Code, rec.Body.String(), http.StatusBadRequest)
 	}
@@ -83,7 +83,7 @@ func TestHandler_XhrSendInvalidInput(t *testing.T) {
 	// unexpected EOF
 	req, _ = http.NewRequest("POST", "/server/session/xhr_send", strings.NewReader("[\"x"))
 	rec = httptest.NewRecorder()
-	h.xhrSend(rec, req)
+	withSessionID(h.xhrSend)(rec, req)
 	if rec.Code != http.StatusBadRequest || rec.Body.String() != "Broken JSON encoding." {
 		t.Errorf("Unexpected response, got '%d,%s' expected '%d,Broken JSON encoding.'", rec.Code, rec.Body.String(), http.StatusBadRequest)
 	}
@@ -93,7 +93,7 @@ func TestHandler_XhrSendSessionNotFound(t *testing.T) {
 	h := Handler{}
 	req, _ := http.NewRequest("POST", "/server/session/xhr_send", strings.NewReader("[\"some message\"]"))
 	rec := httptest.NewRecorder()
-	h.xhrSend(rec, req)
+	withSessionID(h.xhrSend)(rec, req)
 	if rec.Code != http.StatusNotFound {
 		t.Errorf("Unexpected response status, got '%d' expected '%d'", rec.Code, http.StatusNotFound)
 	}
@@ -103,27 +103,69 @@ func TestHandler_XhrPoll(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
-	h.xhrPoll(rw, req)
+	withSessionID(h.xhrPoll)(rw, req)
 	if rw.Header().Get("content-type") != "application/javascript; charset=UTF-8" {
 		t.Errorf("Wrong content type received, got '%s'", rw.Header().Get("content-type"))
 	}
-	sess, _ := h.sessionByRequest(req)
+	sess, _ := h.sessionByRequest(req, "session")
 	if rt := sess.ReceiverType(); rt != ReceiverTypeXHR {
 		t.Errorf("Unexpected recevier type, got '%v', extected '%v'", rt, ReceiverTypeXHR)
 	}
 }
 
+func TestHandler_XhrPollSendAfterOpen(t *testing.T) {
+	for _, asyncWriter := range []bool{false, true} {
+		opts := testOptions
+		opts.AsyncWriter = asyncWriter
+		sent := make(chan struct{})
+		h := NewHandler("", opts, func(s Session) {
+			_ = s.Send("hello") // races with the open frame written by the first poll
+			close(sent)
+			<-s.Context().Done()
+		})
+		poll := func() string {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
+			done := make(chan struct{})
+			go func() {
+				h.ServeHTTP(rw, req)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("Poll did not return, async writer: %v", asyncWriter)
+			}
+			return rw.Body.String()
+		}
+		if body := poll(); body != "o\n" {
+			t.Errorf("Unexpected body of the first poll, got '%s', async writer: %v", body, asyncWriter)
+		}
+		<-sent
+		if body := poll(); body != "a[\"hello\"]\n" {
+			t.Errorf("Unexpected body of the second poll, got '%s', async writer: %v", body, asyncWriter)
+		}
+		sess, _ := h.sessions.get("session")
+		if out := sess.Stats().MessagesOut; out != 1 {
+			t.Errorf("Unexpected number of messages out, got '%d', async writer: %v", out, asyncWriter)
+		}
+		sess.close()
+	}
+}
+
 func TestHandler_XhrPollConnectionInterrupted(t *testing.T) {
 	h := newTestHandler()
+	// the session must be closed by the interrupted request
+	h.options.KeepSessionOnHandlerReturn = true
 	sess := newTestSession()
 	sess.state = SessionActive
-	h.sessions["session"] = sess
+	storeTestSession(h, "session", sess)
 	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
 	ctx, cancel := context.WithCancel(req.Context())
 	req = req.WithContext(ctx)
 	rw := httptest.NewRecorder()
 	cancel()
-	h.xhrPoll(rw, req)
+	withSessionID(h.xhrPoll)(rw, req)
 	time.Sleep(1 * time.Millisecond)
 	sess.mux.Lock()
 	if sess.state != SessionClosed {
@@ -136,11 +178,11 @@ func TestHandler_XhrPollAnotherConnectionExists(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/server/session/xhr", nil)
 	// turn of timeoutes and heartbeats
 	sess := newSession(req, "session", time.Hour, time.Hour)
-	h.sessions["session"] = sess
+	storeTestSession(h, "session", sess)
 	noError(t, sess.attachReceiver(newTestReceiver()))
 	req, _ = http.NewRequest("POST", "/server/session/xhr", nil)
 	rw2 := httptest.NewRecorder()
-	h.xhrPoll(rw2, req)
+	withSessionID(h.xhrPoll)(rw2, req)
 	if rw2.Body.String() != "c[2010,\"Another connection still open\"]\n" {
 		t.Errorf("Unexpected body, got '%s'", rw2.Body)
 	}
@@ -150,12 +192,12 @@ func TestHandler_XhrStreaming(t *testing.T) {
 	h := newTestHandler()
 	rw := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/xhr_streaming", nil)
-	h.xhrStreaming(rw, req)
+	withSessionID(h.xhrStreaming)(rw, req)
 	expectedBody := strings.Repeat("h", 2048) + "\no\n"
 	if rw.Body.String() != expectedBody {
 		t.Errorf("Unexpected body, got '%s' expected '%s'", rw.Body, expectedBody)
 	}
-	sess, _ := h.sessionByRequest(req)
+	sess, _ := h.sessionByRequest(req, "session")
 	if rt := sess.ReceiverType(); rt != ReceiverTypeXHRStreaming {
 		t.Errorf("Unexpected recevier type, got '%v', extected '%v'", rt, ReceiverTypeXHRStreaming)
 	}
@@ -163,6 +205,8 @@ func TestHandler_XhrStreaming(t *testing.T) {
 
 func TestHandler_XhrStreamingAnotherReceiver(t *testing.T) {
 	h := newTestHandler()
+	// the first receiver must stay attached, not be closed with the session once the handler function returns
+	h.options.KeepSessionOnHandlerReturn = true
 	h.options.ResponseLimit = 4096
 	rw1 := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/server/session/xhr_streaming", nil)
@@ -170,19 +214,72 @@ func TestHandler_XhrStreamingAnotherReceiver(t *testing.T) {
 	req = req.WithContext(ctx)
 	go func() {
 		rec := httptest.NewRecorder()
-		h.xhrStreaming(rec, req)
+		withSessionID(h.xhrStreaming)(rec, req)
 		expectedBody := strings.Repeat("h", 2048) + "\n" + "c[2010,\"Another connection still open\"]\n"
 		if rec.Body.String() != expectedBody {
 			t.Errorf("Unexpected body got '%s', expected '%s', ", rec.Body, expectedBody)
 		}
 		cancel()
 	}()
-	h.xhrStreaming(rw1, req)
+	withSessionID(h.xhrStreaming)(rw1, req)
+}
+
+func TestHandler_XhrStreamingReceiverTakeover(t *testing.T) {
+	h := newTestHandler()
+	// the session must survive the takeover, not be closed once the handler function returns
+	h.options.KeepSessionOnHandlerReturn = true
+	h.options.ResponseLimit = 4096
+	h.options.ReceiverConflictPolicy = ReceiverConflictTakeover
+	req1, _ := http.NewRequest("POST", "/server/session/xhr_streaming", nil)
+	rw1 := httptest.NewRecorder()
+	done1 := make(chan struct{})
+	go func() {
+		withSessionID(h.xhrStreaming)(rw1, req1)
+		close(done1)
+	}()
+	var sess *session
+	for sess == nil || sess.ReceiverType() != ReceiverTypeXHRStreaming {
+		time.Sleep(time.Millisecond)
+		sess, _ = h.sessions.get("session")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req2, _ := http.NewRequestWithContext(ctx, "POST", "/server/session/xhr_streaming", nil)
+	rw2 := httptest.NewRecorder()
+	done2 := make(chan struct{})
+	go func() {
+		withSessionID(h.xhrStreaming)(rw2, req2)
+		close(done2)
+	}()
+	select {
+	case <-done1:
+	case <-time.After(time.Second):
+		t.Fatalf("Old receiver should be closed by takeover")
+	}
+	if expected := "o\n" + cFrame + "\n"; !strings.HasSuffix(rw1.Body.String(), expected) {
+		t.Errorf("Old receiver should get close frame, got '%s'", rw1.Body)
+	}
+	noError(t, sess.Send("message"))
+	time.Sleep(10 * time.Millisecond)
+	if sess.GetSessionState() != SessionActive {
+		t.Errorf("Session should stay active after takeover, got '%v'", sess.GetSessionState())
+	}
+	cancel()
+	<-done2
+	expectedBody := strings.Repeat("h", 2048) + "\n" + "a[\"message\"]\n"
+	if rw2.Body.String() != expectedBody {
+		t.Errorf("Unexpected body got '%s', expected '%s'", rw2.Body, expectedBody)
+	}
+}
+
+// storeTestSession stores sess in the handler's session table the way a request creating the session does
+func storeTestSession(h *Handler, id string, sess *session) {
+	_, _, _ = h.sessions.getOrCreate(id, func() (*session, error) { return sess, nil })
 }
 
 // various test only structs
 func newTestHandler() *Handler {
-	h := &Handler{sessions: make(map[string]*session)}
+	h := &Handler{}
 	h.options.HeartbeatDelay = time.Hour
 	h.options.DisconnectDelay = time.Hour
 	h.handlerFunc = func(s Session) {}